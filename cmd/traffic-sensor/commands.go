@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/diagnostics"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/syslog"
+	"github.com/joho/godotenv"
+)
+
+// runCommand executes a CLI subcommand if one was given.
+// Returns false if the sensor itself should be started.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "test-rule":
+		err = testRuleCommand(args[1:])
+	case "syslog-diagnostics":
+		err = syslogDiagnosticsCommand(args[1:])
+	case "replay-syslog":
+		err = replaySyslogCommand(args[1:])
+	default:
+		return false
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// testRuleCommand tests syslog extraction rules against sample lines
+func testRuleCommand(args []string) error {
+	fs := flag.NewFlagSet("test-rule", flag.ExitOnError)
+	ruleName := fs.String("rule", "", "only test the rule with this name")
+	program := fs.String("program", "", "program name to match against (default: taken from each line)")
+	sender := fs.String("sender", "", "sender IP to match against rule sender conditions")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: traffic-sensor test-rule [flags] <rules-file> [samples-file]")
+		fmt.Fprintln(fs.Output(), "Reads samples from stdin if no samples file is given.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("missing rules file")
+	}
+
+	rules, err := syslog.LoadRules(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *ruleName != "" {
+		var selected []syslog.ExtractionRule
+		for _, rule := range rules {
+			if rule.Name == *ruleName {
+				selected = append(selected, rule)
+			}
+		}
+		if len(selected) == 0 {
+			return fmt.Errorf("rule %q not found", *ruleName)
+		}
+		rules = selected
+	}
+
+	var samples io.Reader = os.Stdin
+	if fs.NArg() > 1 {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("failed to open samples file: %w", err)
+		}
+		defer f.Close()
+		samples = f
+	}
+
+	return syslog.TestRules(os.Stdout, rules, *program, *sender, samples)
+}
+
+// syslogDiagnosticsCommand prints the parse failure report of a running sensor
+func syslogDiagnosticsCommand(args []string) error {
+	godotenv.Load()
+
+	fs := flag.NewFlagSet("syslog-diagnostics", flag.ExitOnError)
+	addr := fs.String("addr", os.Getenv("DIAGNOSTICS_LISTEN_ADDR"), "diagnostics address of the running sensor")
+	asJSON := fs.Bool("json", false, "print the raw JSON report")
+	reset := fs.Bool("reset", false, "clear the report after printing it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: traffic-sensor syslog-diagnostics [flags]")
+		fmt.Fprintln(fs.Output(), "The sensor must run with DIAGNOSTICS_LISTEN_ADDR set.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *addr == "" {
+		fs.Usage()
+		return fmt.Errorf("no diagnostics address, set DIAGNOSTICS_LISTEN_ADDR or -addr")
+	}
+	url := "http://" + *addr + diagnostics.SyslogPath
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	req, err := newDiagnosticsRequest(http.MethodGet, url)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch diagnostics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch diagnostics: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read diagnostics: %w", err)
+	}
+	if *asJSON {
+		os.Stdout.Write(body)
+	} else {
+		var report syslog.ParseFailureReport
+		if err := json.Unmarshal(body, &report); err != nil {
+			return fmt.Errorf("failed to decode diagnostics: %w", err)
+		}
+		if err := syslog.WriteParseFailureReport(os.Stdout, report); err != nil {
+			return err
+		}
+	}
+
+	if *reset {
+		req, err := newDiagnosticsRequest(http.MethodDelete, url)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to reset diagnostics: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("failed to reset diagnostics: %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// newDiagnosticsRequest authenticates with AUTH_SECRET, as the sensor expects
+func newDiagnosticsRequest(method, url string) (*http.Request, error) {
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("AUTH_SECRET is not set")
+	}
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(diagnostics.AuthHeader, secret)
+	return req, nil
+}
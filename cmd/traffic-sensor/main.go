@@ -15,6 +15,7 @@ import (
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/arbiter"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/blocklist"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/bootstrap"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/diagnostics"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/sqlite"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/uptime"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/whitelist"
@@ -24,6 +25,10 @@ import (
 )
 
 func main() {
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
 	fmt.Print(assets.LogoContent)
 
 	var wg sync.WaitGroup
@@ -45,9 +50,17 @@ func main() {
 	cfg := config.Load()
 	log.Printf("Config loaded: %+v", cfg)
 
+	if err := utils.InitTransport(cfg); err != nil {
+		log.Fatalf("TLS and proxy setup failed: %v", err)
+	}
 	utils.InitLogger(cfg)
 
 	zap.L().Info("Traffic Sensor starting up...")
+	zap.L().Info("Outbound proxy",
+		zap.String("arbiter", utils.ProxyFor(cfg.NfgArbiterUrl)),
+		zap.String("heartbeat", utils.ProxyFor(cfg.HeartbeatUrl)),
+		zap.String("loki", utils.ProxyFor(cfg.LokiAddress)),
+	)
 
 	err := sqlite.Init(cfg.SqliteDbPath)
 	if err != nil {
@@ -60,6 +73,12 @@ func main() {
 		zap.L().Fatal("Startup failed", zap.Error(err))
 	}
 
+	// Local diagnostics endpoint, see the syslog-diagnostics command
+	if cfg.DiagnosticsListenAddr != "" {
+		wg.Add(1)
+		go diagnostics.StartServer(rootCtx, cfg, &wg)
+	}
+
 	// WS connection for receiving updates
 	updaterWs := arbiter.NewUpdateStreamerImpl()
 
@@ -93,13 +112,13 @@ func main() {
 				if err := arbiter.SyncSensorConfig(rootCtx, cfg, wm, nil); err != nil {
 					zap.L().Error("Failed to sync sensor config", zap.Error(err))
 				}
-				if err := wm.Sync(cfg); err != nil {
+				if err := wm.Sync(rootCtx, cfg); err != nil {
 					zap.L().Error("Failed to sync whitelists", zap.Error(err))
 				}
-				if err := blocklist.Sync(cfg); err != nil {
+				if err := blocklist.Sync(rootCtx, cfg); err != nil {
 					zap.L().Error("Failed to sync blocklists", zap.Error(err))
 				}
-				if err := arbiter.Sync(cfg); err != nil {
+				if err := arbiter.Sync(rootCtx, cfg); err != nil {
 					zap.L().Error("Failed to sync ip-scores", zap.Error(err))
 				}
 
@@ -119,7 +138,14 @@ func main() {
 				zap.L().Info("Heartbeat loop exiting")
 				return
 			case <-ticker.C:
-				uptime.SendHeartbeat(cfg.SensorName, cfg.AuthSecret, cfg.HeartbeatIdentifier, cfg.HeartbeatUrl)
+				offline, _ := arbiter.Offline()
+				err := uptime.SendHeartbeat(rootCtx, cfg, uptime.Status{
+					Offline: offline,
+					Queued:  arbiter.GetRetryQueue(cfg).GetQueueSize(),
+				})
+				if err != nil {
+					zap.L().Error("Failed to send heartbeat", zap.Error(err))
+				}
 			}
 		}
 	}()
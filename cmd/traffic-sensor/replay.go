@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/arbiter"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/blocklist"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/sqlite"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/syslog"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/whitelist"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/utils"
+	"github.com/joho/godotenv"
+)
+
+// replaySyslogCommand runs a file of syslog lines through the parsing and evaluation path
+func replaySyslogCommand(args []string) error {
+	godotenv.Load()
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("replay-syslog", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "do not contact the arbiter: scores come from the local database, no whitelists or blocklists apply and nothing is sent")
+	sender := fs.String("sender", "", "sender IP the lines are treated as received from")
+	parser := fs.String("parser", "", "only use this parser, like a listener's parser profile")
+	threshold := fs.Int("alert-threshold", -1, "alert threshold (default: the arbiter's, no alerts in dry-run mode)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: traffic-sensor replay-syslog [flags] <file>")
+		fmt.Fprintln(fs.Output(), "Reads one raw RFC 3164/5424 message or bare message body per line, \"-\" reads stdin.")
+		fmt.Fprintln(fs.Output(), "Without -dry-run alerts and recommendations are sent to the arbiter directly, bypassing the retry queue.")
+		fmt.Fprintln(fs.Output(), "Failed sends are shown in the results and not retried.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("missing syslog file")
+	}
+
+	var samples io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to open syslog file: %w", err)
+		}
+		defer f.Close()
+		samples = f
+	}
+
+	if err := sqlite.Init(cfg.SqliteDbPath); err != nil {
+		return fmt.Errorf("failed to open score database: %w", err)
+	}
+	if err := sqlite.InitCache(cfg.IpScoreCacheSize); err != nil {
+		return err
+	}
+	if err := arbiter.InitRecommendCache(cfg.RecommendationsCacheSize); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	wm := whitelist.NewWhitelistManager()
+	cfg.AlertThreshold = math.MaxInt32
+	if !*dryRun {
+		if err := utils.InitTransport(cfg); err != nil {
+			return fmt.Errorf("failed to set up TLS and proxy: %w", err)
+		}
+		if err := blocklist.Sync(ctx, cfg); err != nil {
+			return fmt.Errorf("failed to sync blocklists: %w", err)
+		}
+		if err := wm.Sync(ctx, cfg); err != nil {
+			return fmt.Errorf("failed to sync whitelists: %w", err)
+		}
+		if err := arbiter.SyncAlertThreshold(ctx, cfg); err != nil {
+			return fmt.Errorf("failed to sync alert threshold: %w", err)
+		}
+	}
+	if *threshold >= 0 {
+		cfg.AlertThreshold = int32(*threshold)
+	}
+
+	decide := func(direction, ip, relatedIP string, source types.Source) string {
+		if *dryRun {
+			return arbiter.Evaluate(cfg, ip, source).Summary()
+		}
+		evaluation, err := arbiter.EvaluateAndSend(ctx, cfg, direction, ip, relatedIP, source)
+		if err != nil {
+			return evaluation.Summary() + " (" + strings.ReplaceAll(err.Error(), "\n", "; ") + ")"
+		}
+		return evaluation.Summary()
+	}
+
+	opts := syslog.ReplayOptions{Sender: *sender, Parser: *parser}
+	return syslog.Replay(os.Stdout, cfg, wm, opts, samples, decide)
+}
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,6 +19,14 @@ type Config struct {
 	NfgArbiterUrl            string
 	NfgArbiterHost           string
 	InsecureSkipVerifyTLS    bool
+	ArbiterTLSCAFile         string
+	ArbiterTLSCertFile       string
+	ArbiterTLSKeyFile        string
+	ArbiterTLSPins           []string
+	OutboundProxy            string
+	OutboundProxyUsername    string
+	OutboundProxyPassword    string
+	OutboundNoProxy          []string
 	SqliteDbPath             string
 	IpScoreCacheSize         int
 	RecommendationsCacheSize int
@@ -26,13 +37,48 @@ type Config struct {
 	RunSyslog                bool
 	SyslogListenAddr         string
 	SyslogPort               int
+	SyslogSingleIPEvents     bool
+	SyslogTLSPort            int
+	SyslogTLSCertFile        string
+	SyslogTLSKeyFile         string
+	SyslogTLSClientCAFile    string
+	SyslogAllowedSenders     []string
+	SyslogSendersFile        string
+	SyslogRulesFile          string
+	SyslogJSONFieldMappings  []string
+	SyslogForwardFile        string
+	SyslogListenersFile      string
+	SyslogUnixSocket         string
+	SyslogMaxMessageSize     int
+	SyslogClockSkewThreshold time.Duration
+	SyslogTimezone           *time.Location
+	GelfPort                 int
+	GelfParser               string
+	HTTPIngestListenAddr     string
+	HTTPIngestTokens         []string
+	HTTPIngestTLS            bool
+	HTTPIngestParser         string
+	DiagnosticsListenAddr    string
+	RetryQueueMaxSize        int
+	RetryQueueMaxAge         time.Duration
+	SubmitBatchWindow        time.Duration
+	SubmitBatchSize          int
+	AlertAggregationInterval time.Duration
+	APIRequestTimeout        time.Duration
+	APIRequestDeadline       time.Duration
+	ArbiterRateLimit         int
+	CircuitBreakerThreshold  int
+	CircuitBreakerCooldown   time.Duration
 	AlertThreshold           int32
+	ActionPolicy             map[string]string
 }
 
 func Load() *Config {
 	debug, _ := strconv.ParseBool(getEnv("DEBUG", "false"))
 	insecureSkipVerify, _ := strconv.ParseBool(getEnv("STREAMING_SKIP_VERIFY_TLS", "false"))
 	logToLoki, _ := strconv.ParseBool(getEnv("LOG_TO_LOKI", "true"))
+	syslogSingleIPEvents, _ := strconv.ParseBool(getEnv("SYSLOG_SINGLE_IP_EVENTS", "false"))
+	httpIngestTLS, _ := strconv.ParseBool(getEnv("HTTP_INGEST_TLS", "false"))
 
 	cfg := &Config{
 		Debug:                    debug,
@@ -43,6 +89,14 @@ func Load() *Config {
 		NfgArbiterUrl:            getEnv("NFG_ARBITER_URL", "https://arbiter.nxtfireguard.de"),
 		NfgArbiterHost:           getEnv("NFG_ARBITER_HOST", "arbiter.nxtfireguard.de"),
 		InsecureSkipVerifyTLS:    insecureSkipVerify,
+		ArbiterTLSCAFile:         getEnv("ARBITER_TLS_CA_FILE", ""),
+		ArbiterTLSCertFile:       getEnv("ARBITER_TLS_CERT_FILE", ""),
+		ArbiterTLSKeyFile:        getEnv("ARBITER_TLS_KEY_FILE", ""),
+		ArbiterTLSPins:           getEnvList("ARBITER_TLS_PINS"),
+		OutboundProxy:            getEnv("OUTBOUND_PROXY", ""),
+		OutboundProxyUsername:    getEnv("OUTBOUND_PROXY_USERNAME", ""),
+		OutboundProxyPassword:    getEnv("OUTBOUND_PROXY_PASSWORD", ""),
+		OutboundNoProxy:          getEnvList("OUTBOUND_NO_PROXY"),
 		SqliteDbPath:             getEnv("SQLITE_DB_PATH", "/data/ip_scores.db"),
 		IpScoreCacheSize:         getEnvInt("IP_SCORE_CACHE_SIZE", 1000),
 		RecommendationsCacheSize: getEnvInt("RECOMMENDATIONS_CACHE_SIZE", 100),
@@ -51,11 +105,70 @@ func Load() *Config {
 		WsKeepalivePeriod:        30 * time.Second,
 		SyslogListenAddr:         getEnv("SYSLOG_LISTEN_ADDR", "0.0.0.0"),
 		SyslogPort:               getEnvInt("SYSLOG_PORT", 514),
+		SyslogSingleIPEvents:     syslogSingleIPEvents,
+		SyslogTLSPort:            getEnvInt("SYSLOG_TLS_PORT", 0),
+		SyslogTLSCertFile:        getEnv("SYSLOG_TLS_CERT_FILE", ""),
+		SyslogTLSKeyFile:         getEnv("SYSLOG_TLS_KEY_FILE", ""),
+		SyslogTLSClientCAFile:    getEnv("SYSLOG_TLS_CLIENT_CA_FILE", ""),
+		SyslogAllowedSenders:     getEnvList("SYSLOG_ALLOWED_SENDERS"),
+		SyslogSendersFile:        getEnv("SYSLOG_SENDERS_FILE", ""),
+		SyslogRulesFile:          getEnv("SYSLOG_RULES_FILE", ""),
+		SyslogJSONFieldMappings:  getEnvList("SYSLOG_JSON_FIELD_MAPPINGS"),
+		SyslogForwardFile:        getEnv("SYSLOG_FORWARD_FILE", ""),
+		SyslogListenersFile:      getEnv("SYSLOG_LISTENERS_FILE", ""),
+		SyslogUnixSocket:         getEnv("SYSLOG_UNIX_SOCKET", ""),
+		SyslogMaxMessageSize:     getEnvInt("SYSLOG_MAX_MESSAGE_SIZE", 64*1024),
+		SyslogClockSkewThreshold: getEnvDuration("SYSLOG_CLOCK_SKEW_THRESHOLD", 5*time.Minute),
+		SyslogTimezone:           getEnvLocation("SYSLOG_TIMEZONE", time.Local),
+		GelfPort:                 getEnvInt("GELF_PORT", 0),
+		GelfParser:               getEnv("GELF_PARSER", ""),
+		HTTPIngestListenAddr:     getEnv("HTTP_INGEST_LISTEN_ADDR", ""),
+		HTTPIngestTokens:         getEnvList("HTTP_INGEST_TOKENS"),
+		HTTPIngestTLS:            httpIngestTLS,
+		HTTPIngestParser:         getEnv("HTTP_INGEST_PARSER", ""),
+		DiagnosticsListenAddr:    getEnv("DIAGNOSTICS_LISTEN_ADDR", ""),
+		ActionPolicy:             getEnvActionPolicy("ACTION_POLICY"),
+		RetryQueueMaxSize:        getEnvInt("RETRY_QUEUE_MAX_SIZE", 10000),
+		RetryQueueMaxAge:         getEnvDuration("RETRY_QUEUE_MAX_AGE", 24*time.Hour),
+		SubmitBatchWindow:        getEnvDuration("SUBMIT_BATCH_WINDOW", 2*time.Second),
+		SubmitBatchSize:          getEnvInt("SUBMIT_BATCH_SIZE", 100),
+		AlertAggregationInterval: getEnvDuration("ALERT_AGGREGATION_INTERVAL", time.Minute),
+		APIRequestTimeout:        getEnvDuration("API_REQUEST_TIMEOUT", 30*time.Second),
+		APIRequestDeadline:       getEnvDuration("API_REQUEST_DEADLINE", 2*time.Minute),
+		ArbiterRateLimit:         getEnvInt("ARBITER_RATE_LIMIT", 20),
+		CircuitBreakerThreshold:  getEnvInt("CIRCUIT_BREAKER_THRESHOLD", 5),
+		CircuitBreakerCooldown:   getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second),
 	}
 
 	return cfg
 }
 
+// String formats the config for logging with secrets masked
+func (c Config) String() string {
+	// plain has no String method, which would recurse
+	type plain Config
+	masked := plain(c)
+	masked.AuthSecret = mask(c.AuthSecret)
+	masked.OutboundProxyPassword = mask(c.OutboundProxyPassword)
+	if proxyURL, err := url.Parse(c.OutboundProxy); err == nil {
+		masked.OutboundProxy = proxyURL.Redacted()
+	} else {
+		masked.OutboundProxy = mask(c.OutboundProxy)
+	}
+	masked.HTTPIngestTokens = make([]string, len(c.HTTPIngestTokens))
+	for i, token := range c.HTTPIngestTokens {
+		masked.HTTPIngestTokens[i] = mask(token)
+	}
+	return fmt.Sprintf("%+v", masked)
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "xxxxx"
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -63,6 +176,17 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvList splits a comma separated variable, ignoring empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	valueStr, exists := os.LookupEnv(key)
 	if !exists {
@@ -76,3 +200,59 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return valueInt
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		log.Printf("Error converting '%s' to duration, using default %s: %v", key, defaultValue, err)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvLocation loads an IANA time zone such as "Europe/Berlin"
+func getEnvLocation(key string, defaultValue *time.Location) *time.Location {
+	valueStr, exists := os.LookupEnv(key)
+	if !exists || valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := time.LoadLocation(valueStr)
+	if err != nil {
+		log.Printf("Error loading time zone '%s', using default %s: %v", key, defaultValue, err)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvActionPolicy parses "action=policy" pairs such as "allow=alert,deny=record".
+// Actions are allow, deny and unknown, policies alert, recommend and record. Record sends
+// nothing to the arbiter, it counts the evaluations per action in the minutely status log.
+func getEnvActionPolicy(key string) map[string]string {
+	policy := make(map[string]string)
+	for _, entry := range getEnvList(key) {
+		action, value, _ := strings.Cut(entry, "=")
+		action = strings.ToLower(strings.TrimSpace(action))
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		switch action {
+		case "allow", "deny", "unknown":
+		default:
+			log.Printf("Ignoring '%s' entry '%s': unknown action", key, entry)
+			continue
+		}
+		switch value {
+		case "alert", "recommend", "record":
+		default:
+			log.Printf("Ignoring '%s' entry '%s': unknown policy", key, entry)
+			continue
+		}
+		policy[action] = value
+	}
+	return policy
+}
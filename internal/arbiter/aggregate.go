@@ -0,0 +1,241 @@
+package arbiter
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"go.uber.org/zap"
+)
+
+const (
+	maxSightings        = 10000            // keys tracked at once, further alerts are sent as is
+	maxSightingSet      = 1024             // distinct peers and ports counted per key
+	maxReportedPorts    = 64               // ports listed in a sighting
+	sightingIdleTimeout = 30 * time.Minute // keys without hits are forgotten after this
+)
+
+// Sighting summarises the alerts for one key since it was first seen
+type Sighting struct {
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Hits      int64     `json:"hits"`
+	// DistinctPeers counts the related IP's endpoints (address and port)
+	DistinctPeers int `json:"distinctPeers"`
+	// DistinctPorts counts the destination ports, Ports lists the lowest of them
+	DistinctPorts int      `json:"distinctPorts"`
+	Ports         []uint16 `json:"ports,omitempty"`
+}
+
+// sightingKey identifies repeated alerts
+type sightingKey struct {
+	ip         string
+	relatedIp  string
+	direction  string
+	sourceType string
+	sourceName string
+}
+
+type sighting struct {
+	// alert is the latest alert for the key
+	alert     AlertData
+	firstSeen time.Time
+	lastSeen  time.Time
+	hits      int64
+	// reported is the hit count at the last report
+	reported int64
+	peers    map[string]struct{}
+	ports    map[uint16]struct{}
+}
+
+// record adds an alert to the sighting
+func (s *sighting) record(alert AlertData, now time.Time) {
+	s.alert = alert
+	s.lastSeen = now
+	s.hits++
+
+	// The related IP is the destination of source alerts and vice versa
+	peerPort := alert.Source.DstPort
+	if alert.IpType == "destination" {
+		peerPort = alert.Source.SrcPort
+	}
+	peer := alert.RelatedIp
+	if peerPort != 0 {
+		peer = net.JoinHostPort(alert.RelatedIp, strconv.Itoa(int(peerPort)))
+	}
+	if len(s.peers) < maxSightingSet {
+		s.peers[peer] = struct{}{}
+	}
+	if port := alert.Source.DstPort; port != 0 && len(s.ports) < maxSightingSet {
+		s.ports[port] = struct{}{}
+	}
+}
+
+// report returns the latest alert carrying the sighting so far
+func (s *sighting) report() AlertData {
+	s.reported = s.hits
+
+	ports := make([]uint16, 0, len(s.ports))
+	for port := range s.ports {
+		ports = append(ports, port)
+	}
+	slices.Sort(ports)
+	if len(ports) > maxReportedPorts {
+		ports = ports[:maxReportedPorts]
+	}
+
+	alert := s.alert
+	alert.Sighting = &Sighting{
+		FirstSeen:     s.firstSeen,
+		LastSeen:      s.lastSeen,
+		Hits:          s.hits,
+		DistinctPeers: len(s.peers),
+		DistinctPorts: len(s.ports),
+		Ports:         ports,
+	}
+	return alert
+}
+
+// Aggregator turns repeated alerts into sightings. The first alert for a key is sent
+// right away, later ones are summarised and sent once per aggregation interval.
+type Aggregator struct {
+	cfg     *config.Config
+	running atomic.Bool
+
+	// mu guards sightings and untracked, the alerts sent as is since the last flush
+	mu        sync.Mutex
+	sightings map[sightingKey]*sighting
+	untracked int64
+}
+
+var (
+	globalAggregator *Aggregator
+	aggregatorOnce   sync.Once
+)
+
+// GetAggregator returns the singleton alert aggregator
+func GetAggregator(cfg *config.Config) *Aggregator {
+	aggregatorOnce.Do(func() {
+		globalAggregator = &Aggregator{
+			cfg:       cfg,
+			sightings: make(map[sightingKey]*sighting),
+		}
+	})
+	return globalAggregator
+}
+
+// observe records an alert and sends it if its key is new. It returns false if the
+// aggregator is not running or full, and the alert should be sent as is.
+func (a *Aggregator) observe(alert AlertData) bool {
+	if !a.running.Load() {
+		return false
+	}
+
+	key := sightingKey{
+		ip:         alert.Ip,
+		relatedIp:  alert.RelatedIp,
+		direction:  alert.IpType,
+		sourceType: alert.Source.SourceType,
+		sourceName: alert.Source.SourceName,
+	}
+	now := time.Now()
+
+	a.mu.Lock()
+	s, found := a.sightings[key]
+	if !found {
+		if len(a.sightings) >= maxSightings {
+			a.untracked++
+			a.mu.Unlock()
+			return false
+		}
+		s = &sighting{
+			firstSeen: now,
+			peers:     make(map[string]struct{}),
+			ports:     make(map[uint16]struct{}),
+		}
+		a.sightings[key] = s
+	}
+	s.record(alert, now)
+	var report AlertData
+	if !found {
+		report = s.report()
+	}
+	a.mu.Unlock()
+
+	if !found {
+		if err := GetRetryQueue(a.cfg).Send(ItemAlert, report); err != nil {
+			zap.L().Error("Error sending alert", zap.Error(err))
+		}
+	}
+	return true
+}
+
+// Run flushes sightings with new hits every aggregation interval, it returns
+// right away if aggregation is disabled
+func (a *Aggregator) Run(ctx context.Context) {
+	interval := a.cfg.AlertAggregationInterval
+	if interval <= 0 {
+		zap.L().Info("Alert aggregation disabled")
+		return
+	}
+	a.running.Store(true)
+
+	rq := GetRetryQueue(a.cfg)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			a.running.Store(false)
+			// Keep pending sightings for the next start
+			for _, alert := range a.flush(time.Now()) {
+				rq.Add(ItemAlert, newIdempotencyKey(), alert)
+			}
+			zap.L().Info("Alert aggregator stopping")
+			return
+		case now := <-ticker.C:
+			for _, alert := range a.flush(now) {
+				if err := rq.Send(ItemAlert, alert); err != nil {
+					zap.L().Error("Error sending alert", zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+// flush returns the sightings with hits since their last report and forgets idle keys
+func (a *Aggregator) flush(now time.Time) []AlertData {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var reports []AlertData
+	for key, s := range a.sightings {
+		if s.hits > s.reported {
+			reports = append(reports, s.report())
+		}
+		if now.Sub(s.lastSeen) > sightingIdleTimeout {
+			delete(a.sightings, key)
+		}
+	}
+
+	if a.untracked > 0 {
+		zap.L().Warn("Too many alert sightings tracked, sent alerts without aggregation",
+			zap.Int("sightings", len(a.sightings)),
+			zap.Int64("untracked", a.untracked),
+		)
+		a.untracked = 0
+	}
+	if len(reports) > 0 {
+		zap.L().Debug("Flushing alert sightings",
+			zap.Int("reports", len(reports)),
+			zap.Int("sightings", len(a.sightings)),
+		)
+	}
+	return reports
+}
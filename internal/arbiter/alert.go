@@ -2,9 +2,10 @@ package arbiter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"time"
 
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
@@ -12,72 +13,91 @@ import (
 	"go.uber.org/zap"
 )
 
-// SendAlert attempts to send an alert, queuing it for retry if rate limited
+// SendAlert sends an alert, buffering it locally if the arbiter cannot take it.
+// Repeated alerts are aggregated into sightings while the aggregator runs.
 func SendAlert(ipType string, ip string, relatedIp string, source types.Source, cfg *config.Config) error {
-	err := sendAlertInternal(ipType, ip, relatedIp, source, cfg)
-
-	// If rate limited, queue for retry
-	if err != nil && isRateLimitError(err) {
-		zap.L().Warn("Alert rate limited, queuing for retry",
-			zap.String("ip", ip),
-		)
-		GetRetryQueue(cfg).Add("alert", AlertData{
-			IpType:    ipType,
-			Ip:        ip,
-			RelatedIp: relatedIp,
-			Source:    source,
-		})
-		return nil // Don't return error since we queued it
+	alert := AlertData{
+		IpType:    ipType,
+		Ip:        ip,
+		RelatedIp: relatedIp,
+		Source:    source,
 	}
+	if GetAggregator(cfg).observe(alert) {
+		return nil
+	}
+	return GetRetryQueue(cfg).Send(ItemAlert, alert)
+}
 
-	return err
+// alertPayload is the body of an alert, IdempotencyKey is only set within batches
+type alertPayload struct {
+	IdempotencyKey string     `json:"idempotencyKey,omitempty"`
+	IpType         string     `json:"ipType"`
+	Ip             string     `json:"ip"`
+	RelatedIp      string     `json:"relatedIp"`
+	SourceType     string     `json:"sourceType"`
+	SourceName     string     `json:"sourceName"`
+	Category       string     `json:"category,omitempty"`
+	Site           string     `json:"site,omitempty"`
+	Action         string     `json:"action,omitempty"`
+	Hostname       string     `json:"hostname,omitempty"`
+	Program        string     `json:"program,omitempty"`
+	Severity       string     `json:"severity,omitempty"`
+	EventTime      *time.Time `json:"eventTime,omitempty"`
+	SrcPort        uint16     `json:"srcPort,omitempty"`
+	DstPort        uint16     `json:"dstPort,omitempty"`
+	*Sighting
 }
 
-// sendAlertInternal is the actual HTTP call used by the retry queue
-func sendAlertInternal(ipType string, ip string, relatedIp string, source types.Source, cfg *config.Config) error {
-	payload := struct {
-		IpType     string `json:"ipType"`
-		Ip         string `json:"ip"`
-		RelatedIp  string `json:"relatedIp"`
-		SourceType string `json:"sourceType"`
-		SourceName string `json:"sourceName"`
-	}{
-		IpType:     ipType,
-		Ip:         ip,
-		RelatedIp:  relatedIp,
+func newAlertPayload(alert AlertData) alertPayload {
+	source := alert.Source
+	return alertPayload{
+		IpType:     alert.IpType,
+		Ip:         alert.Ip,
+		RelatedIp:  alert.RelatedIp,
 		SourceType: source.SourceType,
 		SourceName: source.SourceName,
+		Category:   source.Category,
+		Site:       source.Site,
+		Action:     source.Action,
+		Hostname:   source.Hostname,
+		Program:    source.Program,
+		Severity:   source.Severity,
+		EventTime:  source.EventTime,
+		SrcPort:    source.SrcPort,
+		DstPort:    source.DstPort,
+		Sighting:   alert.Sighting,
 	}
+}
 
-	body, err := json.Marshal(payload)
+// sendAlertInternal is the actual HTTP call used by the retry queue and EvaluateAndSend
+func sendAlertInternal(ctx context.Context, cfg *config.Config, alert AlertData, idempotencyKey string) error {
+	body, err := json.Marshal(newAlertPayload(alert))
 	if err != nil {
 		zap.L().Error("Failed to marshal alert payload",
 			zap.Error(err),
-			zap.String("ip", ip),
+			zap.String("ip", alert.Ip),
 		)
 		return fmt.Errorf("failed to marshal alert payload: %w", err)
 	}
 
-	client := utils.NewAPIClient(cfg)
-	resp, err := client.DoRequest(utils.RequestOptions{
+	resp, err := apiClient(cfg).DoRequest(ctx, utils.RequestOptions{
 		Endpoint: "/alert",
 		Method:   "POST",
 		Body:     bytes.NewReader(body),
+		Headers: map[string]string{
+			"Content-Type":    "application/json",
+			idempotencyHeader: idempotencyKey,
+		},
 	})
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("alert request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
+	resp.Body.Close()
 
 	zap.L().Debug("Sent alert successfully",
-		zap.String("ip", ip),
-		zap.String("sourceType", source.SourceType),
-		zap.String("sourceName", source.SourceName),
+		zap.String("ip", alert.Ip),
+		zap.String("sourceType", alert.Source.SourceType),
+		zap.String("sourceName", alert.Source.SourceName),
 	)
 	return nil
 }
@@ -0,0 +1,256 @@
+package arbiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/utils"
+	"go.uber.org/zap"
+)
+
+// Batch endpoints, used while the arbiter advertises batch submission
+var batchEndpoints = map[string]string{
+	ItemAlert:          "/alert/batch",
+	ItemRecommendation: "/recommend/batch",
+}
+
+var (
+	client     *utils.APIClient
+	clientOnce sync.Once
+
+	// batchSupported is set from the sensor config sync
+	batchSupported atomic.Bool
+)
+
+// apiClient returns the client shared by all alert and recommendation requests
+func apiClient(cfg *config.Config) *utils.APIClient {
+	clientOnce.Do(func() {
+		client = utils.NewAPIClient(cfg)
+		client.SetRateLimiter(rateLimiter(cfg))
+	})
+	return client
+}
+
+// setBatchSupport records whether the arbiter accepts batch submissions
+func setBatchSupport(supported bool) {
+	if batchSupported.Swap(supported) != supported {
+		zap.L().Info("Arbiter batch submission support changed", zap.Bool("batchSubmission", supported))
+	}
+}
+
+// batchItem is an alert or recommendation on its way to the arbiter
+type batchItem struct {
+	itemType       string
+	idempotencyKey string
+	data           interface{}
+}
+
+// payload returns the item's body as used within a batch
+func (i batchItem) payload() interface{} {
+	switch data := i.data.(type) {
+	case AlertData:
+		payload := newAlertPayload(data)
+		payload.IdempotencyKey = i.idempotencyKey
+		return payload
+	case RecommendationData:
+		return recommendationPayload{IdempotencyKey: i.idempotencyKey, IP: data.IP, Decisions: data.Decisions}
+	}
+	return nil
+}
+
+// runBatcher collects items passed to Send and flushes them once the batch window
+// has passed since the first one, or the batch is full
+func (rq *RetryQueue) runBatcher(ctx context.Context) {
+	window, size := rq.cfg.SubmitBatchWindow, rq.batchSize()
+	rq.batching.Store(true)
+
+	var pending []batchItem
+	timer := time.NewTimer(window)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			rq.batching.Store(false)
+			// Keep whatever is left for the next start
+		drain:
+			for {
+				select {
+				case item := <-rq.batch:
+					pending = append(pending, item)
+				default:
+					break drain
+				}
+			}
+			for _, item := range pending {
+				rq.Add(item.itemType, item.idempotencyKey, item.data)
+			}
+			return
+		case item := <-rq.batch:
+			pending = append(pending, item)
+			if len(pending) == 1 {
+				timer.Reset(window)
+			}
+			if len(pending) < size {
+				continue
+			}
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		rq.flush(ctx, pending)
+		pending = nil
+	}
+}
+
+// flush sends a batch, queuing what could not be delivered
+func (rq *RetryQueue) flush(ctx context.Context, items []batchItem) {
+	// Stay behind items which are already queued, or keep them while the breaker is open
+	if rq.size.Load() > 0 || !rq.breaker.allow() {
+		for _, item := range items {
+			rq.Add(item.itemType, item.idempotencyKey, item.data)
+		}
+		return
+	}
+
+	sent, err := rq.sendItems(ctx, items)
+	if err == nil {
+		rq.breaker.success()
+		connectivity.reachable(0)
+		return
+	}
+	if ctx.Err() != nil {
+		zap.L().Debug("Shutting down, queuing unsent items", zap.Int("items", len(items)-sent))
+	} else if isThrottledError(err) {
+		// Paced locally, the arbiter is fine
+		zap.L().Debug("Request budget exhausted, queuing batch", zap.Int("items", len(items)-sent))
+	} else if isRateLimitError(err) {
+		rq.breaker.failure(err)
+		zap.L().Warn("Arbiter rate limited batch, queuing for retry", zap.Int("items", len(items)-sent))
+	} else {
+		rq.breaker.failure(err)
+		connectivity.unreachable(err)
+	}
+	for _, item := range items[sent:] {
+		rq.Add(item.itemType, item.idempotencyKey, item.data)
+	}
+}
+
+// sendItems delivers items in order, in batches if the arbiter supports them. Items the
+// arbiter rejects are logged and skipped. It returns how many items were handled
+// before the first failure, and that failure.
+func (rq *RetryQueue) sendItems(ctx context.Context, items []batchItem) (int, error) {
+	size := rq.batchSize()
+	sent := 0
+	for sent < len(items) {
+		// Consecutive items of the same type go into one batch
+		end := sent + 1
+		for end < len(items) && end-sent < size && items[end].itemType == items[sent].itemType {
+			end++
+		}
+
+		if end-sent > 1 && batchSupported.Load() {
+			err := rq.postBatch(ctx, items[sent:end])
+			if err == nil {
+				sent = end
+				continue
+			}
+			if isUnsupportedError(err) {
+				zap.L().Warn("Arbiter does not support batch submission, falling back to per-item calls", zap.Error(err))
+				setBatchSupport(false)
+			} else if !isRejectedError(err) {
+				return sent, err
+			} else {
+				// Find the offending items by sending them one by one
+				zap.L().Warn("Arbiter rejected batch, retrying items individually",
+					zap.String("type", items[sent].itemType),
+					zap.Int("items", end-sent),
+					zap.Error(err),
+				)
+			}
+		}
+
+		for ; sent < end; sent++ {
+			item := items[sent]
+			err := rq.deliverData(ctx, item.itemType, item.idempotencyKey, item.data)
+			if err != nil && !isRejectedError(err) {
+				return sent, err
+			}
+			if err != nil {
+				zap.L().Error("Arbiter rejected item, dropping it",
+					zap.String("type", item.itemType),
+					zap.Error(err),
+				)
+				rq.drop("rejected", 1)
+			}
+		}
+	}
+	return sent, nil
+}
+
+// postBatch sends items of one type to its batch endpoint
+func (rq *RetryQueue) postBatch(ctx context.Context, items []batchItem) error {
+	payloads := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		payload := item.payload()
+		if payload == nil {
+			return fmt.Errorf("%w: unknown type %q", errUndeliverable, item.itemType)
+		}
+		payloads = append(payloads, payload)
+	}
+	body, err := json.Marshal(struct {
+		Items []interface{} `json:"items"`
+	}{payloads})
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	resp, err := apiClient(rq.cfg).DoRequest(ctx, utils.RequestOptions{
+		Endpoint: batchEndpoints[items[0].itemType],
+		Method:   "POST",
+		Body:     bytes.NewReader(body),
+		Headers: map[string]string{
+			"Content-Type":    "application/json",
+			idempotencyHeader: newIdempotencyKey(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	zap.L().Debug("Sent batch",
+		zap.String("type", items[0].itemType),
+		zap.Int("items", len(items)),
+	)
+	return nil
+}
+
+func (rq *RetryQueue) batchSize() int {
+	if rq.cfg.SubmitBatchSize > 0 {
+		return rq.cfg.SubmitBatchSize
+	}
+	return 1
+}
+
+// isUnsupportedError reports whether the arbiter lacks an endpoint
+func isUnsupportedError(err error) bool {
+	var statusErr *utils.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	switch statusErr.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
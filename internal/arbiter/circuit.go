@@ -0,0 +1,107 @@
+package arbiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/utils"
+	"go.uber.org/zap"
+)
+
+var (
+	limiter     *utils.RateLimiter
+	limiterOnce sync.Once
+)
+
+// rateLimiter returns the request budgets shared by all alert and recommendation requests.
+// Each endpoint has its own budget, ARBITER_RATE_LIMIT until the arbiter announces one.
+func rateLimiter(cfg *config.Config) *utils.RateLimiter {
+	limiterOnce.Do(func() {
+		limiter = utils.NewRateLimiter(utils.RateLimit{
+			Rate:  float64(cfg.ArbiterRateLimit),
+			Burst: 2 * cfg.ArbiterRateLimit,
+		})
+	})
+	return limiter
+}
+
+// setRateLimits applies the request budgets announced by the arbiter
+func setRateLimits(cfg *config.Config, rateLimits map[string]types.RateLimit) {
+	limits := make(map[string]utils.RateLimit, len(rateLimits))
+	for endpoint, limit := range rateLimits {
+		limits[endpoint] = utils.RateLimit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	if rateLimiter(cfg).SetLimits(limits) {
+		zap.L().Info("Arbiter rate limits changed", zap.Any("rateLimits", rateLimits))
+	}
+}
+
+// circuitBreaker stops deliveries for a cool-down after repeated failures, items are
+// buffered locally meanwhile. Once the cool-down has passed a single delivery probes
+// the arbiter, closing the breaker if it succeeds and reopening it if it fails.
+type circuitBreaker struct {
+	// threshold is the number of consecutive failures opening the breaker, 0 disables it
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	// openUntil is zero while the breaker is closed
+	openUntil time.Time
+	// probeUntil is set while a probe is under way, another may start after it
+	probeUntil time.Time
+}
+
+// allow reports whether a delivery may be attempted now
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) || now.Before(b.probeUntil) {
+		return false
+	}
+	b.probeUntil = now.Add(b.cooldown)
+	return true
+}
+
+// success closes the breaker after a delivery went through
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.openUntil.IsZero() {
+		zap.L().Info("Arbiter circuit breaker closed, resuming deliveries")
+	}
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.probeUntil = time.Time{}
+}
+
+// failure counts a failed delivery and opens the breaker at the threshold or after a failed probe
+func (b *circuitBreaker) failure(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.openUntil.IsZero() && b.failures < b.threshold {
+		return
+	}
+
+	if b.openUntil.IsZero() {
+		zap.L().Warn("Arbiter circuit breaker open, buffering alerts and recommendations locally",
+			zap.Int("failures", b.failures),
+			zap.Duration("cooldown", b.cooldown),
+			zap.Error(err),
+		)
+	} else if !b.probeUntil.IsZero() {
+		zap.L().Debug("Arbiter circuit breaker probe failed, staying open", zap.Error(err))
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	b.probeUntil = time.Time{}
+}
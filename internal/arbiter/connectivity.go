@@ -0,0 +1,55 @@
+package arbiter
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// connectivityState tracks whether the arbiter is reachable, judged by alert and
+// recommendation deliveries
+type connectivityState struct {
+	mu      sync.Mutex
+	offline bool
+	since   time.Time
+}
+
+var connectivity connectivityState
+
+// unreachable marks the arbiter offline after a failed delivery
+func (c *connectivityState) unreachable(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.offline {
+		return
+	}
+	c.offline = true
+	c.since = time.Now()
+	zap.L().Warn("Arbiter unreachable, buffering alerts and recommendations locally",
+		zap.String("state", "offline"),
+		zap.Error(err),
+	)
+}
+
+// reachable marks the arbiter online after a successful delivery
+func (c *connectivityState) reachable(queueSize int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.offline {
+		return
+	}
+	c.offline = false
+	zap.L().Info("Arbiter reachable again, draining buffered alerts and recommendations",
+		zap.String("state", "online"),
+		zap.Duration("offlineFor", time.Since(c.since).Round(time.Second)),
+		zap.Int("queueSize", queueSize),
+	)
+}
+
+// Offline reports whether the arbiter is unreachable and since when
+func Offline() (bool, time.Time) {
+	connectivity.mu.Lock()
+	defer connectivity.mu.Unlock()
+	return connectivity.offline, connectivity.since
+}
@@ -2,28 +2,44 @@ package arbiter
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/sqlite"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/utils"
 	"go.uber.org/zap"
 )
 
-// QueuedItem represents something that needs to be retried
-type QueuedItem struct {
-	ItemType  string      // "alert" or "recommendation"
-	Data      interface{} // the actual data to send
-	Attempts  int
-	NextRetry time.Time
-}
+// Types of queued items
+const (
+	ItemAlert          = "alert"
+	ItemRecommendation = "recommendation"
+)
+
+const (
+	retryInterval     = 5 * time.Second
+	retryBatchSize    = 100
+	maxRetryBackoff   = 5 * time.Minute
+	statusLogInterval = time.Minute
+	idempotencyHeader = "Idempotency-Key"
+)
 
 type AlertData struct {
 	IpType    string
 	Ip        string
 	RelatedIp string
 	Source    types.Source
+	// Sighting summarises repeated alerts, nil for alerts sent without aggregation
+	Sighting *Sighting `json:",omitempty"`
 }
 
 type RecommendationData struct {
@@ -31,11 +47,31 @@ type RecommendationData struct {
 	Decisions []types.Decision
 }
 
-// RetryQueue manages items that failed due to rate limiting
+// RetryQueue buffers alerts and recommendations in SQLite while the arbiter cannot
+// take them and drains them in order once it can. Items are dropped only if they
+// are rejected by the arbiter or exceed the maximum queue size or age. Items are
+// delivered at least once, the arbiter deduplicates them by their idempotency key.
 type RetryQueue struct {
-	mu    sync.RWMutex
-	items []QueuedItem
-	cfg   *config.Config
+	cfg *config.Config
+	// size mirrors the number of queued items, new items are queued behind them
+	size atomic.Int64
+
+	// batch feeds the batcher, which runs while batching is true
+	batch    chan batchItem
+	batching atomic.Bool
+
+	// breaker holds deliveries back while the arbiter keeps failing
+	breaker circuitBreaker
+
+	// Backoff of the queue processor, only used by its goroutine
+	failures    int
+	nextAttempt time.Time
+
+	// mu guards ctx, the processor's context which bounds requests made by Send,
+	// and dropped, the drop counts by reason since they were last logged
+	mu      sync.Mutex
+	ctx     context.Context
+	dropped map[string]int64
 }
 
 var (
@@ -47,140 +83,326 @@ var (
 func GetRetryQueue(cfg *config.Config) *RetryQueue {
 	queueOnce.Do(func() {
 		globalRetryQueue = &RetryQueue{
-			items: make([]QueuedItem, 0),
-			cfg:   cfg,
+			cfg:     cfg,
+			batch:   make(chan batchItem, 10*max(cfg.SubmitBatchSize, 1)),
+			ctx:     context.Background(),
+			dropped: make(map[string]int64),
+			breaker: circuitBreaker{
+				threshold: cfg.CircuitBreakerThreshold,
+				cooldown:  cfg.CircuitBreakerCooldown,
+			},
 		}
 	})
 	return globalRetryQueue
 }
 
-// Add adds an item to the retry queue
-func (rq *RetryQueue) Add(itemType string, data interface{}) {
+// newIdempotencyKey returns a random key identifying a request across retries
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Send delivers an item to the arbiter, or queues it if the arbiter cannot take it now.
+// While items are queued new ones are queued behind them, so that they arrive in order.
+// With a batch window items are collected and sent together. Items rejected by the
+// arbiter are logged and dropped.
+func (rq *RetryQueue) Send(itemType string, data interface{}) error {
+	item := batchItem{itemType: itemType, idempotencyKey: newIdempotencyKey(), data: data}
+	if rq.size.Load() > 0 {
+		rq.Add(item.itemType, item.idempotencyKey, item.data)
+		return nil
+	}
+	if rq.batching.Load() {
+		select {
+		case rq.batch <- item:
+		default:
+			// The batcher is behind, keep the item for the queue processor
+			rq.Add(item.itemType, item.idempotencyKey, item.data)
+		}
+		return nil
+	}
+	rq.flush(rq.requestContext(), []batchItem{item})
+	return nil
+}
+
+// requestContext returns the context for requests made outside the processor
+func (rq *RetryQueue) requestContext() context.Context {
 	rq.mu.Lock()
 	defer rq.mu.Unlock()
+	return rq.ctx
+}
+
+// Add stores an item for retry under the idempotency key it was first sent with
+func (rq *RetryQueue) Add(itemType string, idempotencyKey string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		zap.L().Error("Failed to marshal queued item", zap.String("type", itemType), zap.Error(err))
+		rq.drop("invalid", 1)
+		return
+	}
 
-	item := QueuedItem{
-		ItemType:  itemType,
-		Data:      data,
-		Attempts:  0,
-		NextRetry: time.Now().Add(5 * time.Second), // Initial retry after 5 seconds
+	inserted, err := sqlite.EnqueueRetry(sqlite.QueuedRecord{
+		IdempotencyKey: idempotencyKey,
+		ItemType:       itemType,
+		Payload:        payload,
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		zap.L().Error("Failed to store item in retry queue", zap.String("type", itemType), zap.Error(err))
+		rq.drop("store-failed", 1)
+		return
+	}
+	// Limits are enforced by the queue processor
+	if inserted {
+		rq.size.Add(1)
 	}
 
-	rq.items = append(rq.items, item)
-	zap.L().Info("Added item to retry queue",
+	zap.L().Debug("Added item to retry queue",
 		zap.String("type", itemType),
-		zap.Int("queueSize", len(rq.items)),
+		zap.Int("queueSize", rq.GetQueueSize()),
 	)
 }
 
 // ProcessQueue processes items ready for retry
 func (rq *RetryQueue) ProcessQueue(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
+	rq.mu.Lock()
+	rq.ctx = ctx
+	rq.mu.Unlock()
+
+	// Items queued before a restart are picked up again
+	rq.enforceLimits()
+	if size := rq.GetQueueSize(); size > 0 {
+		zap.L().Info("Loaded persisted retry queue", zap.Int("queueSize", size))
+	}
+
+	if rq.cfg.SubmitBatchWindow > 0 {
+		go rq.runBatcher(ctx)
+	}
+
+	ticker := time.NewTicker(retryInterval)
 	defer ticker.Stop()
+	statusTicker := time.NewTicker(statusLogInterval)
+	defer statusTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			zap.L().Info("Retry queue processor stopping")
+			rq.logStatus()
+			logRecorded()
+			zap.L().Info("Retry queue processor stopping", zap.Int("queueSize", rq.GetQueueSize()))
 			return
 		case <-ticker.C:
-			rq.processReadyItems()
+			rq.enforceLimits()
+			if time.Now().Before(rq.nextAttempt) {
+				continue
+			}
+			rq.processReadyItems(ctx)
+		case <-statusTicker.C:
+			rq.logStatus()
+			logRecorded()
 		}
 	}
 }
 
-func (rq *RetryQueue) processReadyItems() {
-	rq.mu.Lock()
-	defer rq.mu.Unlock()
-
-	now := time.Now()
-	var remaining []QueuedItem
-
-	for _, item := range rq.items {
-		if now.Before(item.NextRetry) {
-			// Not ready yet, keep in queue
-			remaining = append(remaining, item)
-			continue
+// processReadyItems delivers queued items in order until one fails
+func (rq *RetryQueue) processReadyItems(ctx context.Context) {
+	for ctx.Err() == nil && rq.breaker.allow() {
+		records, err := sqlite.OldestRetries(retryBatchSize)
+		if err != nil {
+			zap.L().Error("Failed to read retry queue", zap.Error(err))
+			return
+		}
+		if len(records) == 0 {
+			return
 		}
 
-		// Try to process the item
-		success := false
-		var err error
+		items := make([]batchItem, 0, len(records))
+		decoded := records[:0]
+		for _, record := range records {
+			item, err := decodeRecord(record)
+			if err != nil {
+				zap.L().Error("Dropping undeliverable queued item",
+					zap.String("type", record.ItemType),
+					zap.Error(err),
+				)
+				rq.drop("invalid", 1)
+				if err := sqlite.DeleteRetry(record.ID); err != nil {
+					zap.L().Error("Failed to remove item from retry queue", zap.Error(err))
+				}
+				continue
+			}
+			items = append(items, item)
+			decoded = append(decoded, record)
+		}
+		records = decoded
 
-		switch item.ItemType {
-		case "alert":
-			if alertData, ok := item.Data.(AlertData); ok {
-				err = sendAlertInternal(alertData.IpType, alertData.Ip, alertData.RelatedIp, alertData.Source, rq.cfg)
-				success = (err == nil)
+		sent, err := rq.sendItems(ctx, items)
+		for _, record := range records[:sent] {
+			if err := sqlite.DeleteRetry(record.ID); err != nil {
+				// Delivered again on the next run, the arbiter deduplicates it
+				zap.L().Error("Failed to remove item from retry queue", zap.Error(err))
+				rq.enforceLimits()
+				return
 			}
-		case "recommendation":
-			if recData, ok := item.Data.(RecommendationData); ok {
-				err = recommendInternal(rq.cfg, recData.IP, recData.Decisions)
-				success = (err == nil)
+			rq.size.Add(-1)
+		}
+		if sent > 0 {
+			rq.failures = 0
+			rq.nextAttempt = time.Time{}
+		}
+		if err != nil {
+			// Throttled items are retried on the next tick, without counting as a failure
+			if ctx.Err() == nil && !isThrottledError(err) {
+				rq.breaker.failure(err)
+				rq.backoff(records[sent], err)
 			}
+			return
 		}
+		rq.breaker.success()
+		connectivity.reachable(rq.GetQueueSize())
+	}
+}
 
-		if success {
-			zap.L().Info("Successfully retried queued item",
-				zap.String("type", item.ItemType),
-				zap.Int("attempts", item.Attempts+1),
-			)
-			// Item succeeded, don't add back to queue
-			continue
-		}
+// backoff pauses the queue after a failed delivery: 5s, 10s, 20s, 40s, ... up to 5 minutes,
+// or longer if the arbiter asked for it with Retry-After
+func (rq *RetryQueue) backoff(record sqlite.QueuedRecord, err error) {
+	if dbErr := sqlite.RecordRetryAttempt(record.ID); dbErr != nil {
+		zap.L().Error("Failed to record retry attempt", zap.Error(dbErr))
+	}
+	if !isRateLimitError(err) {
+		connectivity.unreachable(err)
+	}
 
-		// Check if we should give up
-		item.Attempts++
-		maxAttempts := 10
-		if item.Attempts >= maxAttempts {
-			zap.L().Warn("Dropping item after max retries",
-				zap.String("type", item.ItemType),
-				zap.Int("attempts", item.Attempts),
-			)
-			continue
-		}
+	delay := maxRetryBackoff
+	if rq.failures < 6 {
+		delay = min(retryInterval<<rq.failures, maxRetryBackoff)
+	}
+	delay = max(delay, utils.RetryAfterOf(err))
+	rq.failures++
+	rq.nextAttempt = time.Now().Add(delay)
+
+	zap.L().Debug("Retry queue delivery failed, pausing",
+		zap.String("type", record.ItemType),
+		zap.Int("attempts", record.Attempts+1),
+		zap.Duration("nextAttempt", delay),
+		zap.Error(err),
+	)
+}
+
+// errUndeliverable marks queued items which can never be sent
+var errUndeliverable = errors.New("undeliverable item")
+
+// decodeRecord restores a queued item with its original idempotency key
+func decodeRecord(record sqlite.QueuedRecord) (batchItem, error) {
+	item := batchItem{itemType: record.ItemType, idempotencyKey: record.IdempotencyKey}
+	var err error
+	switch record.ItemType {
+	case ItemAlert:
+		var alert AlertData
+		err = json.Unmarshal(record.Payload, &alert)
+		item.data = alert
+	case ItemRecommendation:
+		var recommendation RecommendationData
+		err = json.Unmarshal(record.Payload, &recommendation)
+		item.data = recommendation
+	default:
+		err = fmt.Errorf("unknown type %q", record.ItemType)
+	}
+	return item, err
+}
+
+func (rq *RetryQueue) deliverData(ctx context.Context, itemType string, idempotencyKey string, data interface{}) error {
+	switch data := data.(type) {
+	case AlertData:
+		return sendAlertInternal(ctx, rq.cfg, data, idempotencyKey)
+	case RecommendationData:
+		return recommendInternal(ctx, rq.cfg, data.IP, data.Decisions, idempotencyKey)
+	}
+	return fmt.Errorf("%w: unknown type %q", errUndeliverable, itemType)
+}
 
-		// Calculate exponential backoff: 5s, 10s, 20s, 40s, ... up to 5 minutes
-		backoff := time.Duration(5*(1<<item.Attempts)) * time.Second
-		if backoff > 5*time.Minute {
-			backoff = 5 * time.Minute
+// enforceLimits drops items older than the maximum age and the oldest items beyond the maximum size
+func (rq *RetryQueue) enforceLimits() {
+	if rq.cfg.RetryQueueMaxAge > 0 {
+		expired, err := sqlite.ExpireRetries(time.Now().Add(-rq.cfg.RetryQueueMaxAge))
+		if err != nil {
+			zap.L().Error("Failed to expire retry queue items", zap.Error(err))
 		}
-		item.NextRetry = now.Add(backoff)
+		rq.drop("expired", expired)
+	}
+	if rq.cfg.RetryQueueMaxSize > 0 {
+		trimmed, err := sqlite.TrimRetries(rq.cfg.RetryQueueMaxSize)
+		if err != nil {
+			zap.L().Error("Failed to trim retry queue", zap.Error(err))
+		}
+		rq.drop("overflow", trimmed)
+	}
 
-		zap.L().Debug("Requeueing item",
-			zap.String("type", item.ItemType),
-			zap.Int("attempts", item.Attempts),
-			zap.Duration("nextRetry", backoff),
-			zap.Error(err),
-		)
+	size, err := sqlite.CountRetries()
+	if err != nil {
+		zap.L().Error("Failed to count retry queue", zap.Error(err))
+		return
+	}
+	rq.size.Store(int64(size))
+}
+
+func (rq *RetryQueue) drop(reason string, count int64) {
+	if count <= 0 {
+		return
+	}
+	rq.mu.Lock()
+	rq.dropped[reason] += count
+	rq.mu.Unlock()
+}
+
+// logStatus logs the items dropped since the last call in aggregate, and the offline state
+func (rq *RetryQueue) logStatus() {
+	rq.mu.Lock()
+	dropped := rq.dropped
+	rq.dropped = make(map[string]int64)
+	rq.mu.Unlock()
 
-		remaining = append(remaining, item)
+	if len(dropped) > 0 {
+		fields := []zap.Field{zap.Int("queueSize", rq.GetQueueSize())}
+		for reason, count := range dropped {
+			fields = append(fields, zap.Int64(reason, count))
+		}
+		zap.L().Warn("Dropped items from retry queue", fields...)
 	}
 
-	rq.items = remaining
-	if len(rq.items) > 0 {
-		zap.L().Debug("Retry queue status", zap.Int("itemsRemaining", len(rq.items)))
+	if offline, since := Offline(); offline {
+		zap.L().Warn("Arbiter still unreachable, buffering alerts and recommendations",
+			zap.String("state", "offline"),
+			zap.Duration("offlineFor", time.Since(since).Round(time.Second)),
+			zap.Int("queueSize", rq.GetQueueSize()),
+		)
 	}
 }
 
 // GetQueueSize returns the current queue size (for monitoring)
 func (rq *RetryQueue) GetQueueSize() int {
-	rq.mu.RLock()
-	defer rq.mu.RUnlock()
-	return len(rq.items)
+	return int(rq.size.Load())
 }
 
-// RateLimitError represents a 429 response
-type RateLimitError struct {
-	StatusCode int
-	Message    string
+func isRateLimitError(err error) bool {
+	return utils.ErrorKindOf(err) == utils.KindRateLimit
 }
 
-func (e *RateLimitError) Error() string {
-	return fmt.Sprintf("rate limit exceeded (429): %s", e.Message)
+// isThrottledError reports whether a request was held back by the local rate limiter
+func isThrottledError(err error) bool {
+	return utils.ErrorKindOf(err) == utils.KindThrottled
 }
 
-func isRateLimitError(err error) bool {
-	_, ok := err.(*RateLimitError)
-	return ok
+// isRejectedError reports whether the arbiter refused a request, which is not retried.
+// Authentication failures are retried, they are fixed on the arbiter's side.
+func isRejectedError(err error) bool {
+	if errors.Is(err, errUndeliverable) {
+		return true
+	}
+	var statusErr *utils.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	return statusErr.Kind() == utils.KindClient && statusErr.StatusCode != http.StatusRequestTimeout
 }
@@ -2,49 +2,43 @@ package arbiter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net"
-	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/recommender"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/utils"
 	"go.uber.org/zap"
 )
 
-// recommend attempts to send a recommendation, queuing it for retry if rate limited
+// recommend sends a recommendation, buffering it locally if the arbiter cannot take it
 func recommend(cfg *config.Config, ip string, decisions []types.Decision) error {
-	err := recommendInternal(cfg, ip, decisions)
-
-	// If rate limited, queue for retry
-	if err != nil && isRateLimitError(err) {
-		zap.L().Warn("Recommendation rate limited, queuing for retry",
-			zap.String("ip", ip),
-		)
-		GetRetryQueue(cfg).Add("recommendation", RecommendationData{
-			IP:        ip,
-			Decisions: decisions,
-		})
-		return nil // Don't return error since we queued it
-	}
+	return GetRetryQueue(cfg).Send(ItemRecommendation, RecommendationData{
+		IP:        ip,
+		Decisions: decisions,
+	})
+}
 
-	return err
+// recommendationPayload is the body of a recommendation, IdempotencyKey is only set within batches
+type recommendationPayload struct {
+	IdempotencyKey string           `json:"idempotencyKey,omitempty"`
+	IP             string           `json:"ip"`
+	Decisions      []types.Decision `json:"decisions"`
 }
 
-// recommendInternal is the actual HTTP call (used by retry queue)
-func recommendInternal(cfg *config.Config, ip string, decisions []types.Decision) error {
-	payload := struct {
-		IP        string           `json:"ip"`
-		Decisions []types.Decision `json:"decisions"`
-	}{
+// recommendInternal is the actual HTTP call used by the retry queue and EvaluateAndSend
+func recommendInternal(ctx context.Context, cfg *config.Config, ip string, decisions []types.Decision, idempotencyKey string) error {
+	body, err := json.Marshal(recommendationPayload{
 		IP:        ip,
 		Decisions: decisions,
-	}
-
-	body, err := json.Marshal(payload)
+	})
 	if err != nil {
 		zap.L().Error("Failed to marshal block report payload",
 			zap.Error(err),
@@ -53,97 +47,147 @@ func recommendInternal(cfg *config.Config, ip string, decisions []types.Decision
 		return fmt.Errorf("failed to marshal block report payload: %w", err)
 	}
 
-	maxRetries := 3
-	backoff := time.Second
+	zap.L().Debug("Sending recommendation request", zap.String("ip", ip))
 
-	zap.L().Debug("Sending recommendation request",
-		zap.String("ip", ip),
-		zap.String("url", fmt.Sprintf("%s/recommend", cfg.NfgArbiterUrl)),
-	)
+	resp, err := apiClient(cfg).DoRequest(ctx, utils.RequestOptions{
+		Endpoint: "/recommend",
+		Method:   "POST",
+		Body:     bytes.NewReader(body),
+		Headers: map[string]string{
+			"Content-Type":    "application/json",
+			idempotencyHeader: idempotencyKey,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
 
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		req, err := http.NewRequest("POST", fmt.Sprintf("%s/recommend", cfg.NfgArbiterUrl), bytes.NewBuffer(body))
-		if err != nil {
-			zap.L().Error("Failed to create request",
-				zap.Error(err),
-				zap.String("ip", ip),
-			)
-			return fmt.Errorf("failed to create request: %w", err)
-		}
+	zap.L().Debug("Recommendation request succeeded", zap.String("ip", ip))
+	return nil
+}
 
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X_AUTH_KEY", cfg.AuthSecret)
-		req.Header.Set("X_SENSOR_NAME", cfg.SensorName)
+// Action policies, chosen per firewall action through cfg.ActionPolicy
+const (
+	PolicyAlert     = "alert"     // alert above the threshold and recommend blocks
+	PolicyRecommend = "recommend" // recommend blocks without alerting
+	PolicyRecord    = "record"    // only record the evaluation
+)
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			zap.L().Warn("Request failed, retrying",
-				zap.Int("attempt", attempt+1),
-				zap.String("ip", ip),
-				zap.Error(err),
-			)
-			if attempt < maxRetries {
-				time.Sleep(backoff)
-				backoff *= 2
-				continue
-			}
-			zap.L().Error("Failed to send request after retries",
-				zap.Int("maxRetries", maxRetries),
-				zap.String("ip", ip),
-				zap.Error(err),
-			)
-			return fmt.Errorf("failed to send request after retries: %w", err)
-		}
-		defer resp.Body.Close()
-
-		// Check for rate limit - don't retry on 429, let caller queue it
-		if resp.StatusCode == http.StatusTooManyRequests {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			return &RateLimitError{
-				StatusCode: resp.StatusCode,
-				Message:    string(bodyBytes),
-			}
-		}
+// actionPolicy returns the policy for the firewall action of a log source, alerting by
+// default. Packet captures and single-IP security events carry no action and always alert.
+func actionPolicy(cfg *config.Config, source types.Source) string {
+	switch source.SourceType {
+	case "syslog", "http", "gelf":
+	default:
+		return PolicyAlert
+	}
+	if source.Category != "" {
+		return PolicyAlert
+	}
+	action := source.Action
+	if action == "" {
+		action = "unknown"
+	}
+	if policy, ok := cfg.ActionPolicy[action]; ok {
+		return policy
+	}
+	return PolicyAlert
+}
 
-		if resp.StatusCode == http.StatusOK {
-			zap.L().Debug("Recommendation request succeeded",
-				zap.String("ip", ip),
-				zap.Int("status", resp.StatusCode),
-			)
-			return nil
+// recorded counts evaluations per firewall action that the record policy kept from
+// alerting and recommending, logged with the retry queue status
+var recorded = struct {
+	sync.Mutex
+	counts map[string]int64
+}{counts: make(map[string]int64)}
+
+func recordEvaluation(source types.Source) {
+	action := source.Action
+	if action == "" {
+		action = "unknown"
+	}
+	recorded.Lock()
+	recorded.counts[action]++
+	recorded.Unlock()
+}
+
+// logRecorded logs and resets the recorded evaluation counts
+func logRecorded() {
+	recorded.Lock()
+	counts := recorded.counts
+	recorded.counts = make(map[string]int64)
+	recorded.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+	fields := make([]zap.Field, 0, len(counts))
+	for action, count := range counts {
+		fields = append(fields, zap.Int64(action, count))
+	}
+	zap.L().Info("Recorded evaluations under the record policy", fields...)
+}
+
+// Evaluation is the verdict for a single IP
+type Evaluation struct {
+	Score  int32
+	Policy string
+	// Alert is true if an alert is due under the policy and alert threshold
+	Alert bool
+	// Blocks are the blocking decisions to recommend, empty under the record policy
+	Blocks []types.Decision
+}
+
+// Summary describes the evaluation in one line, e.g. "score=85 alert recommend=bl1,bl2"
+func (e Evaluation) Summary() string {
+	parts := []string{fmt.Sprintf("score=%d", e.Score)}
+	if e.Policy == PolicyRecord {
+		parts = append(parts, PolicyRecord)
+	}
+	if e.Alert {
+		parts = append(parts, "alert")
+	}
+	if len(e.Blocks) > 0 {
+		blocklists := make([]string, 0, len(e.Blocks))
+		for _, d := range e.Blocks {
+			blocklists = append(blocklists, d.Blocklist)
 		}
+		parts = append(parts, "recommend="+strings.Join(blocklists, ","))
+	}
+	return strings.Join(parts, " ")
+}
 
-		// Retry if status code is 5xx
-		if resp.StatusCode >= 500 && attempt < maxRetries {
-			zap.L().Warn("Server error, retrying",
-				zap.Int("attempt", attempt+1),
+// Evaluate scores an IP and applies the action policy without contacting the arbiter
+func Evaluate(cfg *config.Config, ip string, source types.Source) Evaluation {
+	decisions, score := recommender.ShouldBlock(ip)
+
+	evaluation := Evaluation{
+		Score:  score,
+		Policy: actionPolicy(cfg, source),
+	}
+	if evaluation.Policy == PolicyRecord {
+		return evaluation
+	}
+	evaluation.Alert = evaluation.Policy == PolicyAlert && score >= cfg.AlertThreshold
+
+	// Filter only the blocking ones
+	for _, d := range decisions {
+		if d.Block {
+			zap.L().Debug("Blocking decision made",
 				zap.String("ip", ip),
-				zap.Int("status", resp.StatusCode),
+				zap.String("blocklist", d.Blocklist),
+				zap.String("reason", d.Reason),
 			)
-			time.Sleep(backoff)
-			backoff *= 2
-			continue
+			evaluation.Blocks = append(evaluation.Blocks, d)
 		}
-
-		// Non-retriable error
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		zap.L().Error("Non-retriable error from recommendation request",
-			zap.String("ip", ip),
-			zap.Int("status", resp.StatusCode),
-			zap.String("response", string(bodyBytes)),
-		)
-		return fmt.Errorf("non-retriable error, status %d: %s", resp.StatusCode, string(bodyBytes))
 	}
-
-	zap.L().Error("Request failed after all attempts",
-		zap.Int("maxRetries", maxRetries),
-		zap.String("ip", ip),
-	)
-	return fmt.Errorf("request failed after %d attempts", maxRetries)
+	return evaluation
 }
 
-// EvaluateAndAct evaluates an IP and takes appropriate action
-func EvaluateAndAct(cfg *config.Config, ipType string, ip string, relatedIp string, source types.Source) {
+// EvaluateAndAct evaluates an IP, takes the action chosen by the action policy and
+// returns the evaluation it acted on
+func EvaluateAndAct(cfg *config.Config, ipType string, ip string, relatedIp string, source types.Source) Evaluation {
 	start := time.Now()
 
 	zap.L().Debug("Evaluating IP", zap.String("ip", ip))
@@ -153,44 +197,43 @@ func EvaluateAndAct(cfg *config.Config, ipType string, ip string, relatedIp stri
 		zap.L().Debug("Not a valid IP Address, skipping",
 			zap.String("ip", ip),
 		)
-		return
+		return Evaluation{}
 	}
 
-	decisions, score := recommender.ShouldBlock(ip)
+	evaluation := Evaluate(cfg, ip, source)
+	if evaluation.Policy == PolicyRecord {
+		recordEvaluation(source)
+		zap.L().Debug("Recorded evaluation, action policy skips alert and recommendation",
+			zap.String("ip", ip),
+			zap.String("relatedIp", relatedIp),
+			zap.String("action", source.Action),
+			zap.Int32("score", evaluation.Score),
+		)
+		return evaluation
+	}
 
-	if score >= cfg.AlertThreshold {
+	if evaluation.Alert {
 		err := SendAlert(ipType, ip, relatedIp, source, cfg)
 		if err != nil {
 			zap.L().Error("Error sending alert", zap.Error(err))
 		}
 	}
 
-	// Filter only the blocking ones
-	var blocksToReport []types.Decision
-	for _, d := range decisions {
-		if d.Block {
-			zap.L().Debug("Blocking decision made",
-				zap.String("ip", ip),
-				zap.String("blocklist", d.Blocklist),
-				zap.String("reason", d.Reason),
-			)
-			blocksToReport = append(blocksToReport, d)
-		}
-	}
-
 	// If there are any blocking decisions, act on them
-	if len(blocksToReport) > 0 {
+	if len(evaluation.Blocks) > 0 {
 		zap.L().Debug("Reporting block", zap.String("ip", ip))
-		key := generateCacheKey(ip, blocksToReport)
+		key := generateCacheKey(ip, evaluation.Blocks)
 
 		if _, found := RecommendCache.Get(key); found {
 			zap.L().Debug("Duplicate recommendation skipped", zap.String("ip", ip))
-			return
+			return evaluation
 		}
 
 		zap.L().Debug("Reporting block", zap.String("ip", ip))
 		RecommendCache.Add(key, struct{}{})
-		recommend(cfg, ip, blocksToReport)
+		if err := recommend(cfg, ip, evaluation.Blocks); err != nil {
+			zap.L().Error("Error sending recommendation", zap.Error(err))
+		}
 	} else {
 		zap.L().Debug("No blocking decision", zap.String("ip", ip))
 	}
@@ -199,4 +242,45 @@ func EvaluateAndAct(cfg *config.Config, ipType string, ip string, relatedIp stri
 		zap.String("ip", ip),
 		zap.Duration("duration", time.Since(start)),
 	)
+	return evaluation
+}
+
+// EvaluateAndSend evaluates an IP like EvaluateAndAct, but sends its alert and recommendation
+// to the arbiter directly instead of through the aggregator and the retry queue. Failed
+// sends are returned and not retried, replays use it to stay out of the live queue.
+func EvaluateAndSend(ctx context.Context, cfg *config.Config, ipType string, ip string, relatedIp string, source types.Source) (Evaluation, error) {
+	if net.ParseIP(ip) == nil {
+		return Evaluation{}, nil
+	}
+
+	evaluation := Evaluate(cfg, ip, source)
+	if evaluation.Policy == PolicyRecord {
+		return evaluation, nil
+	}
+
+	var errs []error
+	if evaluation.Alert {
+		alert := AlertData{IpType: ipType, Ip: ip, RelatedIp: relatedIp, Source: source}
+		if err := sendAlertInternal(ctx, cfg, alert, newIdempotencyKey()); err != nil {
+			errs = append(errs, fmt.Errorf("alert failed: %w", err))
+		}
+	}
+
+	if len(evaluation.Blocks) > 0 {
+		key := generateCacheKey(ip, evaluation.Blocks)
+		if _, found := RecommendCache.Get(key); found {
+			return evaluation, errors.Join(errs...)
+		}
+		if err := recommendInternal(ctx, cfg, ip, evaluation.Blocks, newIdempotencyKey()); err != nil {
+			errs = append(errs, fmt.Errorf("recommendation failed: %w", err))
+		} else {
+			RecommendCache.Add(key, struct{}{})
+		}
+	}
+	return evaluation, errors.Join(errs...)
+}
+
+// HandleTraffic is the types.EvaluationFunc of the traffic monitors and the syslog server
+func HandleTraffic(cfg *config.Config, ipType string, ip string, relatedIp string, source types.Source) {
+	EvaluateAndAct(cfg, ipType, ip, relatedIp, source)
 }
@@ -38,7 +38,7 @@ func HandleChangeSniffTraffic(rootCtx context.Context, cfg *config.Config, white
 		go func() {
 			defer wg.Done()
 			zap.L().Info("Started traffic monitoring")
-			traffic.MonitorAllInterfaces(ctx, cfg, whitelistManager, EvaluateAndAct, wg)
+			traffic.MonitorAllInterfaces(ctx, cfg, whitelistManager, HandleTraffic, wg)
 		}()
 	}
 }
@@ -61,7 +61,7 @@ func HandleChangeRunSyslog(rootCtx context.Context, cfg *config.Config, whitelis
 		go func() {
 			defer wg.Done()
 			zap.L().Info("Started syslog server")
-			syslog.StartSyslogServer(ctx, cfg, whitelistManager, EvaluateAndAct, wg)
+			syslog.StartSyslogServer(ctx, cfg, whitelistManager, HandleTraffic, wg)
 		}()
 	}
 }
@@ -0,0 +1,280 @@
+package arbiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/sqlite"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/whitelist"
+	"go.uber.org/zap"
+)
+
+const (
+	// updateSeqKey holds the sequence up to which all updates were applied
+	updateSeqKey = "update_seq"
+	// resyncAll resyncs everything, used when it is unknown which updates were missed
+	resyncAll           = "all"
+	resyncRetryInterval = 30 * time.Second
+	// Missed updates are replayed by the arbiter on reconnect, up to maxReplayGap of them.
+	// If they have not arrived after replayTimeout, or the gap is larger, everything is resynced.
+	maxReplayGap  = 1000
+	replayTimeout = time.Minute
+	// maxSequenceDrop is how far below the applied sequence an update may be before it is
+	// taken for a restarted counter rather than a stray resend
+	maxSequenceDrop = 100
+)
+
+// Update types which are resynced after a gap
+var resyncTypes = []string{"score-update", "blocklist-update", "whitelist-update", "config-update"}
+
+// sequenceTracker follows the sequence numbers of updates. It detects gaps and resets and
+// persists the sequence up to which all updates were applied, which is resumed from on reconnect.
+type sequenceTracker struct {
+	mu sync.Mutex
+	// received is the highest sequence received, 0 before the first one
+	received uint64
+	// applied is the persisted sequence
+	applied uint64
+	// pending counts the outstanding updates and resyncs of sequences not applied yet
+	pending map[uint64]int
+	// missing are the sequences of a gap, awaiting their replay
+	missing map[uint64]bool
+}
+
+func newSequenceTracker() *sequenceTracker {
+	t := &sequenceTracker{pending: make(map[uint64]int), missing: make(map[uint64]bool)}
+	value, err := sqlite.GetState(updateSeqKey)
+	if err != nil {
+		zap.L().Error("[update] Failed to load last applied update sequence", zap.Error(err))
+	} else if value != "" {
+		if t.applied, err = strconv.ParseUint(value, 10, 64); err != nil {
+			zap.L().Error("[update] Invalid last applied update sequence", zap.String("value", value))
+		}
+	}
+	t.received = t.applied
+	return t
+}
+
+// resume returns the sequence to resume from on reconnect. The arbiter replays the
+// updates after it, those still pending are skipped as duplicates.
+func (t *sequenceTracker) resume() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.received = t.applied
+	return t.applied
+}
+
+// sequenceEvent is the outcome of receiving an update
+type sequenceEvent int
+
+const (
+	// sequenceNext is the expected update, a replayed one, or one without a sequence
+	sequenceNext sequenceEvent = iota
+	// sequenceDuplicate was received before
+	sequenceDuplicate
+	// sequenceStale is older than the applied sequence, e.g. a stray resend
+	sequenceStale
+	// sequenceReplay follows missed updates which the arbiter can replay
+	sequenceReplay
+	// sequenceGap follows more missed updates than are replayed
+	sequenceGap
+	// sequenceReset went far backwards, the arbiter's counter restarted
+	sequenceReset
+)
+
+// receive registers the sequence of an update. For a replay it returns the first missed
+// sequence, those up to seq are missing until they arrive. For a gap it also returns the
+// first missed sequence, which is pending until the resync is done. After a reset the
+// sequence is rebased and the update's own sequence is pending until the resync is done.
+func (t *sequenceTracker) receive(seq uint64) (sequenceEvent, uint64) {
+	if seq == 0 {
+		return sequenceNext, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.missing[seq] {
+		delete(t.missing, seq)
+		t.received = max(t.received, seq)
+		t.pending[seq]++
+		return sequenceNext, 0
+	}
+	if t.pending[seq] > 0 || seq == t.received {
+		// Replayed after a reconnect while still pending
+		t.received = max(t.received, seq)
+		return sequenceDuplicate, 0
+	}
+	if seq < t.received {
+		if seq >= t.applied || (seq != 1 && t.applied-seq <= maxSequenceDrop) {
+			return sequenceStale, 0
+		}
+		t.received = seq
+		t.pending = map[uint64]int{seq: 2}
+		t.missing = make(map[uint64]bool)
+		t.setApplied(seq - 1)
+		return sequenceReset, seq
+	}
+
+	event, missed := sequenceNext, uint64(0)
+	if t.received > 0 && seq > t.received+1 {
+		missed = t.received + 1
+		if seq-missed <= maxReplayGap {
+			event = sequenceReplay
+			for s := missed; s < seq; s++ {
+				t.missing[s] = true
+			}
+		} else {
+			event = sequenceGap
+			t.pending[missed]++
+		}
+	}
+	t.received = seq
+	t.pending[seq]++
+	return event, missed
+}
+
+// abandonReplay gives up on the missed sequences from up to but excluding to which have not
+// been replayed. It returns true if there were any, from is then pending until a resync is done.
+func (t *sequenceTracker) abandonReplay(from, to uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	abandoned := false
+	for s := from; s < to; s++ {
+		if t.missing[s] {
+			delete(t.missing, s)
+			abandoned = true
+		}
+	}
+	if abandoned {
+		t.pending[from]++
+	}
+	return abandoned
+}
+
+// done marks an update or resync of a sequence as applied and persists the new resume point
+func (t *sequenceTracker) done(seq uint64) {
+	if seq == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending[seq] > 1 {
+		t.pending[seq]--
+	} else {
+		delete(t.pending, seq)
+	}
+
+	applied := t.received
+	for pending := range t.pending {
+		applied = min(applied, pending-1)
+	}
+	for missing := range t.missing {
+		applied = min(applied, missing-1)
+	}
+	if applied > t.applied {
+		t.setApplied(applied)
+	}
+}
+
+// setApplied persists the resume point, the caller holds mu
+func (t *sequenceTracker) setApplied(applied uint64) {
+	t.applied = applied
+	if err := sqlite.SetState(updateSeqKey, strconv.FormatUint(applied, 10)); err != nil {
+		zap.L().Error("[update] Failed to persist last applied update sequence", zap.Error(err))
+	}
+}
+
+// resyncer refetches state when updates were missed or dropped, one resync at a time
+type resyncer struct {
+	mu sync.Mutex
+	// pending are the update types to resync with the sequences they cover
+	pending map[string][]uint64
+	wake    chan struct{}
+}
+
+func newResyncer() *resyncer {
+	return &resyncer{
+		pending: make(map[string][]uint64),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// request schedules a resync of an update type, or of everything for resyncAll
+func (r *resyncer) request(updateType string, seq uint64) {
+	r.mu.Lock()
+	r.pending[updateType] = append(r.pending[updateType], seq)
+	r.mu.Unlock()
+	r.signal()
+}
+
+func (r *resyncer) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run performs requested resyncs until ctx is done, failed ones are retried
+func (r *resyncer) run(ctx context.Context, cfg *config.Config, wm *whitelist.WhitelistManager, wg *sync.WaitGroup, tracker *sequenceTracker) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		}
+
+		r.mu.Lock()
+		pending := r.pending
+		r.pending = make(map[string][]uint64)
+		r.mu.Unlock()
+
+		failed := false
+		for updateType, seqs := range pending {
+			zap.L().Info("[update] Resyncing after missed updates", zap.String("type", updateType))
+			if err := resync(ctx, cfg, wm, wg, updateType); err != nil {
+				zap.L().Error("[update] Resync failed, retrying",
+					zap.String("type", updateType),
+					zap.Duration("retryIn", resyncRetryInterval),
+					zap.Error(err),
+				)
+				r.mu.Lock()
+				r.pending[updateType] = append(r.pending[updateType], seqs...)
+				r.mu.Unlock()
+				failed = true
+				continue
+			}
+			for _, seq := range seqs {
+				tracker.done(seq)
+			}
+		}
+		if failed {
+			time.AfterFunc(resyncRetryInterval, r.signal)
+		}
+	}
+}
+
+// resync refetches the state an update type changes
+func resync(ctx context.Context, cfg *config.Config, wm *whitelist.WhitelistManager, wg *sync.WaitGroup, updateType string) error {
+	switch updateType {
+	case resyncAll:
+		for _, updateType := range resyncTypes {
+			if err := resync(ctx, cfg, wm, wg, updateType); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "score-update":
+		if err := Sync(ctx, cfg); err != nil {
+			return fmt.Errorf("failed to resync ip-scores: %w", err)
+		}
+		sqlite.ScoreCache.Purge()
+		return InitRecommendCache(cfg.RecommendationsCacheSize)
+	case "blocklist-update", "whitelist-update", "config-update":
+		return applyUpdate(ctx, cfg, wm, Update{Type: updateType}, wg)
+	}
+	// Nothing to refetch for unknown types
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/sqlite"
@@ -19,12 +20,17 @@ import (
 	"go.uber.org/zap"
 )
 
-const streamBatchSize = 10000
+const (
+	streamBatchSize = 10000
+	// The score database is large, allow for slow links
+	scoreSyncTimeout = 15 * time.Minute
+)
 
-func Sync(cfg *config.Config) error {
+func Sync(ctx context.Context, cfg *config.Config) error {
 	client := utils.NewAPIClient(cfg)
-	resp, err := client.DoRequest(utils.RequestOptions{
+	resp, err := client.DoRequest(ctx, utils.RequestOptions{
 		Endpoint: "/sync/score",
+		Timeout:  scoreSyncTimeout,
 	})
 	if err != nil {
 		return err
@@ -197,7 +203,7 @@ func ReloadSubsystems(rootCtx context.Context, cfg *config.Config, wm *whitelist
 			zap.L().Info("Started traffic monitoring")
 			var subsystemWg sync.WaitGroup // Use local WaitGroup
 			subsystemWg.Add(1)
-			traffic.MonitorAllInterfaces(ctx, cfg, wm, EvaluateAndAct, &subsystemWg)
+			traffic.MonitorAllInterfaces(ctx, cfg, wm, HandleTraffic, &subsystemWg)
 			subsystemWg.Wait()
 			zap.L().Info("Traffic monitoring goroutine exited")
 		}()
@@ -220,7 +226,7 @@ func ReloadSubsystems(rootCtx context.Context, cfg *config.Config, wm *whitelist
 			zap.L().Info("Started syslog server")
 			var subsystemWg sync.WaitGroup // Use local WaitGroup
 			subsystemWg.Add(1)
-			syslog.StartSyslogServer(ctx, cfg, wm, EvaluateAndAct, &subsystemWg)
+			syslog.StartSyslogServer(ctx, cfg, wm, HandleTraffic, &subsystemWg)
 			subsystemWg.Wait()
 			zap.L().Info("Syslog server goroutine exited")
 		}()
@@ -229,21 +235,40 @@ func ReloadSubsystems(rootCtx context.Context, cfg *config.Config, wm *whitelist
 	zap.L().Info("Subsystem reload complete")
 }
 
-func SyncSensorConfig(rootCtx context.Context, cfg *config.Config, whitelistManager *whitelist.WhitelistManager, wg *sync.WaitGroup) error {
+// fetchSensorConfig retrieves the runtime configuration of the sensor
+func fetchSensorConfig(ctx context.Context, cfg *config.Config) (types.SyncResponse, error) {
 	client := utils.NewAPIClient(cfg)
 
-	resp, err := client.DoRequest(utils.RequestOptions{
+	resp, err := client.DoRequest(ctx, utils.RequestOptions{
 		Endpoint: "/sync",
 	})
 	if err != nil {
-		return err
+		return types.SyncResponse{}, err
 	}
 	defer resp.Body.Close()
 
 	var response types.SyncResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
 		zap.L().Error("Failed to decode alert threshold response", zap.Error(err))
-		return fmt.Errorf("failed to decode response: %w", err)
+		return types.SyncResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return response, nil
+}
+
+// SyncAlertThreshold stores the alert threshold without starting or stopping subsystems
+func SyncAlertThreshold(ctx context.Context, cfg *config.Config) error {
+	response, err := fetchSensorConfig(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	cfg.AlertThreshold = response.AlertThreshold
+	return nil
+}
+
+func SyncSensorConfig(rootCtx context.Context, cfg *config.Config, whitelistManager *whitelist.WhitelistManager, wg *sync.WaitGroup) error {
+	response, err := fetchSensorConfig(rootCtx, cfg)
+	if err != nil {
+		return err
 	}
 
 	if cfg.SniffTraffic != response.SniffTraffic || cfg.RunSyslog != response.RunSyslog {
@@ -254,6 +279,8 @@ func SyncSensorConfig(rootCtx context.Context, cfg *config.Config, whitelistMana
 
 	// Update alert threshold
 	cfg.AlertThreshold = response.AlertThreshold
+	setBatchSupport(response.BatchSubmission)
+	setRateLimits(cfg, response.RateLimits)
 
 	zap.L().Info("Stored alert threshold", zap.Int("threshold", int(cfg.AlertThreshold)))
 	return nil
@@ -2,13 +2,15 @@ package arbiter
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,11 +19,14 @@ import (
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/sqlite"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/whitelist"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/utils"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
 type Update struct {
+	// Seq increases by one with every update, 0 if the arbiter does not number them
+	Seq  uint64          `json:"seq,omitempty"`
 	Type string          `json:"type"`
 	Data json.RawMessage `json:"data"`
 }
@@ -35,10 +40,17 @@ type ScoreUpdate struct {
 type UpdateStreamerImpl struct {
 	conn *websocket.Conn
 	mu   sync.RWMutex
+
+	seq    *sequenceTracker
+	resync *resyncer
 }
 
+// NewUpdateStreamerImpl creates the update streamer, SQLite must be initialized
 func NewUpdateStreamerImpl() *UpdateStreamerImpl {
-	return &UpdateStreamerImpl{}
+	return &UpdateStreamerImpl{
+		seq:    newSequenceTracker(),
+		resync: newResyncer(),
+	}
 }
 
 func (u *UpdateStreamerImpl) SetConn(c *websocket.Conn) {
@@ -67,13 +79,20 @@ func (u *UpdateStreamerImpl) StartListening(rootCtx context.Context, cfg *config
 				zap.L().Debug("[update] Worker processing update",
 					zap.Int("workerID", workerID),
 					zap.String("type", update.Type))
-				ProcessUpdate(rootCtx, cfg, wm, update, wg)
+				if err := ProcessUpdate(rootCtx, cfg, wm, update, wg); err != nil {
+					// Not marked as applied until the resync succeeds
+					u.resync.request(update.Type, update.Seq)
+					continue
+				}
+				u.seq.done(update.Seq)
 			}
 		}(i)
 	}
 
 	go func() {
 		conn := u.GetConn()
+		// Stops the workers of this connection
+		defer close(updateChan)
 		defer func() {
 			if r := recover(); r != nil {
 				zap.L().Error("Recovered from panic in websocket read loop", zap.Any("recover", r))
@@ -133,14 +152,60 @@ func (u *UpdateStreamerImpl) StartListening(rootCtx context.Context, cfg *config
 					continue
 				}
 
+				replay := false
+				switch event, resyncSeq := u.seq.receive(data.Seq); event {
+				case sequenceDuplicate:
+					zap.L().Debug("[update] Skipping update received before",
+						zap.Uint64("seq", data.Seq),
+						zap.String("type", data.Type))
+					continue
+				case sequenceStale:
+					zap.L().Warn("[update] Skipping out of order update",
+						zap.Uint64("seq", data.Seq),
+						zap.String("type", data.Type))
+					continue
+				case sequenceReplay:
+					zap.L().Warn("[update] Missed updates, reconnecting for a replay",
+						zap.Uint64("from", resyncSeq),
+						zap.Uint64("to", data.Seq-1))
+					replay = true
+					from, to := resyncSeq, data.Seq
+					time.AfterFunc(replayTimeout, func() {
+						if u.seq.abandonReplay(from, to) {
+							zap.L().Warn("[update] Missed updates were not replayed, resyncing",
+								zap.Uint64("from", from),
+								zap.Uint64("to", to-1))
+							u.resync.request(resyncAll, from)
+						}
+					})
+				case sequenceGap:
+					zap.L().Warn("[update] Missed updates, resyncing",
+						zap.Uint64("from", resyncSeq),
+						zap.Uint64("to", data.Seq-1))
+					u.resync.request(resyncAll, resyncSeq)
+				case sequenceReset:
+					zap.L().Warn("[update] Update sequence restarted, resyncing",
+						zap.Uint64("seq", data.Seq))
+					u.resync.request(resyncAll, resyncSeq)
+				}
+
 				// Process async in channel
 				select {
 				case updateChan <- data:
 					// Successfully queued
 				default:
-					// Channel full - log warning but don't block the read loop
-					zap.L().Warn("[update] Update channel full, dropping update",
+					// Channel full - don't block the read loop, refetch the state instead
+					zap.L().Warn("[update] Update channel full, resyncing instead",
+						zap.Uint64("seq", data.Seq),
 						zap.String("type", data.Type))
+					u.resync.request(data.Type, data.Seq)
+				}
+
+				if replay {
+					// The arbiter replays from the last applied sequence on reconnect
+					u.SetConn(nil)
+					conn.Close()
+					break
 				}
 			}
 		}
@@ -178,11 +243,10 @@ func PingKeepalive(u *UpdateStreamerImpl, period time.Duration) {
 }
 
 func StartUpdateWebSocketClient(rootCtx context.Context, cfg *config.Config, wm *whitelist.WhitelistManager, updater *UpdateStreamerImpl, wg *sync.WaitGroup) error {
-	var scheme string
-	if cfg.InsecureSkipVerifyTLS {
+	// Plaintext only if the REST API is plaintext as well
+	scheme := "wss"
+	if strings.HasPrefix(cfg.NfgArbiterUrl, "http://") {
 		scheme = "ws"
-	} else {
-		scheme = "wss"
 	}
 
 	u := url.URL{
@@ -194,13 +258,16 @@ func StartUpdateWebSocketClient(rootCtx context.Context, cfg *config.Config, wm
 	headers.Set("X_AUTH_KEY", cfg.AuthSecret)
 	headers.Set("X_SENSOR_NAME", cfg.SensorName)
 
-	dialer := websocket.DefaultDialer
+	// Same CA, client certificate, pins and proxy as the REST client
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = utils.TLSConfig()
+	dialer.Proxy = utils.Proxy
 	if cfg.InsecureSkipVerifyTLS {
-		dialer.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+		dialer.TLSClientConfig.InsecureSkipVerify = true
 	}
 
+	go updater.resync.run(rootCtx, cfg, wm, wg, updater.seq)
+
 	// Backoff configuration
 	initialBackoff := 1 * time.Second
 	maxBackoff := 5 * time.Minute
@@ -208,7 +275,17 @@ func StartUpdateWebSocketClient(rootCtx context.Context, cfg *config.Config, wm
 	backoffMultiplier := 2.0
 
 	for {
-		zap.L().Info("[update] Connecting to update WebSocket", zap.String("url", u.String()))
+		// The arbiter replays the updates after the last applied one
+		lastSeq := updater.seq.resume()
+		if lastSeq > 0 {
+			headers.Set("X_LAST_SEQUENCE", strconv.FormatUint(lastSeq, 10))
+		} else {
+			headers.Del("X_LAST_SEQUENCE")
+		}
+
+		zap.L().Info("[update] Connecting to update WebSocket",
+			zap.String("url", u.String()),
+			zap.Uint64("lastSequence", lastSeq))
 		conn, _, err := dialer.Dial(u.String(), headers)
 		if err != nil {
 			zap.L().Error("[update] Connection failed",
@@ -260,13 +337,21 @@ func StartUpdateWebSocketClient(rootCtx context.Context, cfg *config.Config, wm
 	}
 }
 
-func ProcessUpdate(rootCtx context.Context, cfg *config.Config, wm *whitelist.WhitelistManager, data Update, wg *sync.WaitGroup) {
+func ProcessUpdate(rootCtx context.Context, cfg *config.Config, wm *whitelist.WhitelistManager, data Update, wg *sync.WaitGroup) error {
+	err := applyUpdate(rootCtx, cfg, wm, data, wg)
+	if err != nil {
+		zap.L().Error("[update] Failed to apply update", zap.String("type", data.Type), zap.Error(err))
+	}
+	return err
+}
+
+// applyUpdate applies an update, all but score updates refetch the current state
+func applyUpdate(rootCtx context.Context, cfg *config.Config, wm *whitelist.WhitelistManager, data Update, wg *sync.WaitGroup) error {
 	switch data.Type {
 	case "score-update":
 		var s ScoreUpdate
 		if err := json.Unmarshal(data.Data, &s); err != nil {
-			zap.L().Error("Failed to parse score-update", zap.Error(err))
-			return
+			return fmt.Errorf("failed to parse score-update: %w", err)
 		}
 		zap.L().Info("[update] Processing score-update",
 			zap.String("IP:", s.Ip),
@@ -274,33 +359,29 @@ func ProcessUpdate(rootCtx context.Context, cfg *config.Config, wm *whitelist.Wh
 		)
 		err := sqlite.UpsertIpScore(types.ScoreRecord{IP: s.Ip, NFGScore: s.Score})
 		if err != nil {
-			zap.L().Error("Failed to upsert ip score", zap.Error(err))
-			return
+			return fmt.Errorf("failed to upsert ip score: %w", err)
 		}
 		sqlite.ScoreCache.Remove(s.Ip)
 		removeRecommendCacheEntriesByIP(s.Ip)
 	case "blocklist-update":
 		zap.L().Info("[update] Processing blocklist-update")
-		err := blocklist.Sync(cfg)
-		if err != nil {
-			zap.L().Error("Failed to re-sync blocklists", zap.Error(err))
-			return
+		if err := blocklist.Sync(rootCtx, cfg); err != nil {
+			return fmt.Errorf("failed to re-sync blocklists: %w", err)
 		}
 		// Invalidate entire recommendations cache
-		InitRecommendCache(cfg.RecommendationsCacheSize)
+		return InitRecommendCache(cfg.RecommendationsCacheSize)
 	case "whitelist-update":
 		zap.L().Info("[update] Processing whitelist-update")
-		if err := wm.Sync(cfg); err != nil {
-			zap.L().Error("Failed to re-sync whitelists", zap.Error(err))
-			return
+		if err := wm.Sync(rootCtx, cfg); err != nil {
+			return fmt.Errorf("failed to re-sync whitelists: %w", err)
 		}
 	case "config-update":
 		zap.L().Info("[update] Processing alert-threshold-update")
 		if err := SyncSensorConfig(rootCtx, cfg, wm, wg); err != nil {
-			zap.L().Error("Failed to re-sync whitelists", zap.Error(err))
-			return
+			return fmt.Errorf("failed to re-sync sensor config: %w", err)
 		}
 	default:
 		zap.L().Warn("Unknown update type received", zap.String("type", data.Type))
 	}
+	return nil
 }
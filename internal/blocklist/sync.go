@@ -1,6 +1,7 @@
 package blocklist
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -14,10 +15,10 @@ import (
 var storedBlocklists []types.Blocklist
 var blocklistMutex sync.RWMutex
 
-func Sync(cfg *config.Config) error {
+func Sync(ctx context.Context, cfg *config.Config) error {
 	client := utils.NewAPIClient(cfg)
 
-	resp, err := client.DoRequest(utils.RequestOptions{
+	resp, err := client.DoRequest(ctx, utils.RequestOptions{
 		Endpoint: "/sync/blocklist",
 	})
 	if err != nil {
@@ -24,18 +24,32 @@ func InitializeSystem(rootCtx context.Context, cfg *config.Config, wm *whitelist
 		return err
 	}
 
+	// Deliver alerts and recommendations queued before the last shutdown
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		arbiter.GetRetryQueue(cfg).ProcessQueue(rootCtx)
+	}()
+
+	// Aggregate repeated alerts into sightings
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		arbiter.GetAggregator(cfg).Run(rootCtx)
+	}()
+
 	// Sync IP score DB
-	if err := arbiter.Sync(cfg); err != nil {
+	if err := arbiter.Sync(rootCtx, cfg); err != nil {
 		return err
 	}
 
 	// Pull blocklist/s
-	if err := blocklist.Sync(cfg); err != nil {
+	if err := blocklist.Sync(rootCtx, cfg); err != nil {
 		return err
 	}
 
 	// Pull whitelist/s
-	if err := wm.Sync(cfg); err != nil {
+	if err := wm.Sync(rootCtx, cfg); err != nil {
 		return err
 	}
 
@@ -0,0 +1,105 @@
+package diagnostics
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/syslog"
+	"go.uber.org/zap"
+)
+
+// SyslogPath serves the syslog parse failure report. DELETE resets it.
+const SyslogPath = "/diagnostics/syslog"
+
+// AuthHeader carries AUTH_SECRET, which every request must present
+const AuthHeader = "X_AUTH_KEY"
+
+// StartServer serves the diagnostics endpoints on DIAGNOSTICS_LISTEN_ADDR until ctx is done.
+// The report contains raw log lines, so only loopback addresses are accepted and
+// requests must authenticate with AUTH_SECRET.
+func StartServer(ctx context.Context, cfg *config.Config, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	addr := cfg.DiagnosticsListenAddr
+	if err := checkLoopback(addr); err != nil {
+		zap.L().Error("Not starting diagnostics server", zap.Error(err))
+		return
+	}
+	if cfg.AuthSecret == "" {
+		zap.L().Error("Not starting diagnostics server", zap.Error(errors.New("AUTH_SECRET is not set")))
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(SyslogPath, handleSyslog)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           requireAuth(cfg.AuthSecret, mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	zap.L().Info("Starting diagnostics server", zap.String("address", addr))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		zap.L().Error("Diagnostics server failed", zap.Error(err))
+		return
+	}
+	zap.L().Info("Diagnostics server exited cleanly")
+}
+
+// checkLoopback accepts host:port addresses on localhost or a loopback IP
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid DIAGNOSTICS_LISTEN_ADDR %q: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("DIAGNOSTICS_LISTEN_ADDR %q is not a loopback address", addr)
+}
+
+// requireAuth rejects requests without the auth secret
+func requireAuth(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(AuthHeader)), []byte(secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleSyslog(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(syslog.ParseFailures()); err != nil {
+			zap.L().Warn("Failed to write diagnostics response", zap.Error(err))
+		}
+	case http.MethodDelete:
+		syslog.ResetParseFailures()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
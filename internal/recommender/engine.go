@@ -40,6 +40,15 @@ func ShouldProcessPacket(wm *whitelist.WhitelistManager, src, dst string) bool {
 	return true
 }
 
+// ShouldProcessIP is the single-IP variant of ShouldProcessPacket
+func ShouldProcessIP(wm *whitelist.WhitelistManager, ip string) bool {
+	if wm.IsWhitelisted(ip) {
+		zap.L().Debug("IP is whitelisted, skipping", zap.String("ip", ip))
+		return false
+	}
+	return true
+}
+
 func ShouldBlock(ip string) ([]types.Decision, int32) {
 	var decisions []types.Decision
 
@@ -58,7 +67,7 @@ func ShouldBlock(ip string) ([]types.Decision, int32) {
 			Reason:    fmt.Sprintf("Error retrieving score: %v", err),
 			Blocklist: "N/A",
 		})
-		return decisions, *score
+		return decisions, 0
 	}
 
 	isPrivate, err := privateIpCheck(ip)
@@ -66,6 +66,18 @@ func bootstrapSchema() error {
 		score INTEGER,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
+	CREATE TABLE IF NOT EXISTS retry_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		idempotency_key TEXT NOT NULL UNIQUE,
+		item_type TEXT NOT NULL,
+		payload BLOB NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS sensor_state (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
 	`
 	//zap.L().Debug("Bootstrapping SQLite schema")
 	_, err := db.Exec(schema)
@@ -0,0 +1,103 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+// QueuedRecord is an undelivered arbiter request kept in the retry_queue table
+type QueuedRecord struct {
+	ID             int64
+	IdempotencyKey string
+	ItemType       string
+	Payload        []byte
+	Attempts       int
+	CreatedAt      time.Time
+}
+
+// EnqueueRetry stores a record and reports whether it was added, a record with the same
+// idempotency key is kept as is
+func EnqueueRetry(record QueuedRecord) (bool, error) {
+	result, err := GetDB().Exec(
+		`INSERT OR IGNORE INTO retry_queue (idempotency_key, item_type, payload, attempts, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		record.IdempotencyKey, record.ItemType, record.Payload, record.Attempts, record.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to enqueue retry: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to enqueue retry: %w", err)
+	}
+	return inserted > 0, nil
+}
+
+// OldestRetries returns up to limit records in the order they were queued
+func OldestRetries(limit int) ([]QueuedRecord, error) {
+	rows, err := GetDB().Query(
+		`SELECT id, idempotency_key, item_type, payload, attempts, created_at
+		FROM retry_queue ORDER BY id LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retry queue: %w", err)
+	}
+	defer rows.Close()
+
+	var records []QueuedRecord
+	for rows.Next() {
+		var r QueuedRecord
+		if err := rows.Scan(&r.ID, &r.IdempotencyKey, &r.ItemType, &r.Payload, &r.Attempts, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan retry queue row: %w", err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// RecordRetryAttempt counts a failed delivery attempt
+func RecordRetryAttempt(id int64) error {
+	if _, err := GetDB().Exec(`UPDATE retry_queue SET attempts = attempts + 1 WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to record retry attempt: %w", err)
+	}
+	return nil
+}
+
+// DeleteRetry removes a delivered or dropped record
+func DeleteRetry(id int64) error {
+	if _, err := GetDB().Exec(`DELETE FROM retry_queue WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete retry: %w", err)
+	}
+	return nil
+}
+
+// CountRetries returns the number of queued records
+func CountRetries() (int, error) {
+	var count int
+	if err := GetDB().QueryRow(`SELECT COUNT(*) FROM retry_queue`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count retry queue: %w", err)
+	}
+	return count, nil
+}
+
+// ExpireRetries removes records created before cutoff and returns how many were removed
+func ExpireRetries(cutoff time.Time) (int64, error) {
+	result, err := GetDB().Exec(`DELETE FROM retry_queue WHERE created_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire retries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// TrimRetries removes the oldest records beyond maxSize and returns how many were removed
+func TrimRetries(maxSize int) (int64, error) {
+	result, err := GetDB().Exec(
+		`DELETE FROM retry_queue WHERE id NOT IN (SELECT id FROM retry_queue ORDER BY id DESC LIMIT ?)`,
+		maxSize,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to trim retry queue: %w", err)
+	}
+	return result.RowsAffected()
+}
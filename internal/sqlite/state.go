@@ -0,0 +1,33 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// GetState returns a value kept in the sensor_state table, "" if it is not set
+func GetState(key string) (string, error) {
+	var value string
+	err := GetDB().QueryRow(`SELECT value FROM sensor_state WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read state %q: %w", key, err)
+	}
+	return value, nil
+}
+
+// SetState stores a value in the sensor_state table
+func SetState(key, value string) error {
+	_, err := GetDB().Exec(
+		`INSERT INTO sensor_state (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		key, value,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store state %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package syslog
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+)
+
+var (
+	cefActRe    = regexp.MustCompile(`\bact=(.+?)(?:\s+\w+=|$)`)
+	iosActionRe = regexp.MustCompile(`\b(permitted|denied)\b`)
+	kvActionRe  = regexp.MustCompile(`(?i)\baction\s*[=:]\s*"?([\w-]+(?: with reset)?)`)
+)
+
+// actionFields are the structured log paths holding the firewall action
+var actionFields = []string{"event.action", "action", "act", "disposition"}
+
+// normalizeAction maps vendor specific action words to types.ActionAllow or types.ActionDeny.
+// Unknown words yield an empty action.
+func normalizeAction(action string) string {
+	switch strings.ToLower(strings.TrimSpace(action)) {
+	case "allow", "allowed", "accept", "accepted", "permit", "permitted", "pass", "passed",
+		"est-allowed", "trust", "monitor":
+		return types.ActionAllow
+	case "deny", "denied", "drop", "dropped", "block", "blocked", "reject", "rejected",
+		"block with reset", "interactive block", "interactive block with reset",
+		"reset-both", "reset-client", "reset-server":
+		return types.ActionDeny
+	default:
+		return ""
+	}
+}
+
+func cefAction(msg string) string {
+	if m := cefActRe.FindStringSubmatch(msg); m != nil {
+		return normalizeAction(m[1])
+	}
+	return ""
+}
+
+func ciscoAsaAction(msg string) string {
+	if event, ok := extractCiscoAsa(msg); ok {
+		return event.Action
+	}
+	return ""
+}
+
+func ciscoIosAction(msg string) string {
+	if m := iosActionRe.FindStringSubmatch(msg); m != nil {
+		return normalizeAction(m[1])
+	}
+	return ""
+}
+
+// pfAction reads the action field of pf filterlog CSV lines
+func pfAction(msg string) string {
+	fields := strings.Split(msg, ",")
+	if len(fields) < 7 {
+		return ""
+	}
+	return normalizeAction(fields[6])
+}
+
+func jsonAction(msg string) string {
+	var data interface{}
+	if err := json.Unmarshal([]byte(msg), &data); err != nil {
+		return ""
+	}
+	fields := make(map[string]string)
+	flattenJSON(data, "", fields)
+	return fieldsAction(fields)
+}
+
+func xmlAction(msg string) string {
+	return fieldsAction(flattenXML(msg))
+}
+
+func fieldsAction(fields map[string]string) string {
+	for _, path := range actionFields {
+		if value, ok := lookupField(fields, path); ok {
+			if action := normalizeAction(value); action != "" {
+				return action
+			}
+		}
+	}
+	return ""
+}
+
+// keyValueAction finds action=... style fields in unstructured messages
+func keyValueAction(msg string) string {
+	if m := kvActionRe.FindStringSubmatch(msg); m != nil {
+		return normalizeAction(m[1])
+	}
+	return ""
+}
+
+// extractAction returns the normalised action reported by the named parser
+func extractAction(parser, msg string) string {
+	for _, p := range srcDstParsers {
+		if p.Name == parser && p.Action != nil {
+			return p.Action(msg)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,264 @@
+package syslog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+	"go.uber.org/zap"
+)
+
+// firewallEvent holds the connection details a vendor-specific parser could extract
+type firewallEvent struct {
+	Src          string
+	Dst          string
+	SrcPort      uint16
+	DstPort      uint16
+	Protocol     string
+	Action       string // types.ActionAllow or types.ActionDeny, empty if unknown
+	SrcInterface string
+	DstInterface string
+	MessageID    string
+}
+
+var (
+	asaHeaderRe = regexp.MustCompile(`%(?:ASA|FTD)-(?:session-)?\d-(\d{6}):?\s*(.*)$`)
+
+	// Built inbound TCP connection 123 for outside:1.2.3.4/5555 (1.2.3.4/5555) to inside:10.0.0.1/443 (10.0.0.1/443)
+	// Teardown UDP connection 123 for outside:1.2.3.4/53 to inside:10.0.0.1/5353 duration 0:00:00 bytes 0
+	asaConnRe = regexp.MustCompile(`(?i)^(Built|Teardown)\s+(?:(inbound|outbound)\s+)?(\w+)\s+connection\s+\S+\s+for\s+([^:\s]+):(` + ipPattern + `)/(\d+).*?\s+to\s+([^:\s]+):(` + ipPattern + `)/(\d+)`)
+
+	// Built inbound ICMP connection for faddr 1.2.3.4/0 gaddr 10.0.0.1/0 laddr 10.0.0.1/0
+	// Teardown ICMP connection for faddr 1.2.3.4/0 gaddr 10.0.0.1/0 laddr 10.0.0.1/0 type 8 code 0
+	asaIcmpConnRe = regexp.MustCompile(`(?i)^(Built|Teardown)\s+(?:(inbound|outbound)\s+)?(ICMP\w*)\s+connection\s+for\s+faddr\s+(` + ipPattern + `)/\d+.*?laddr\s+(` + ipPattern + `)/\d+`)
+
+	// Deny tcp src outside:1.2.3.4/5555 dst inside:10.0.0.1/443 by access-group "outside_access_in"
+	// Deny inbound icmp src outside:1.2.3.4 dst inside:10.0.0.1 (type 8, code 0)
+	asaSrcDstRe = regexp.MustCompile(`(?i)^(Deny|Permit)\s+(?:inbound\s+|outbound\s+)?(\w+)\s+src\s+([^:\s]+):(` + ipPattern + `)(?:/(\d+))?\s+dst\s+([^:\s]+):(` + ipPattern + `)(?:/(\d+))?`)
+
+	// access-list outside_in permitted tcp outside/1.2.3.4(5555) -> inside/10.0.0.1(443) hit-cnt 1 first hit
+	asaAccessListRe = regexp.MustCompile(`(?i)^access-list\s+\S+\s+(permitted|denied|est-allowed)\s+(\w+)\s+([^/\s]+)/(` + ipPattern + `)\((\d+)\)\s*->\s*([^/\s]+)/(` + ipPattern + `)\((\d+)\)`)
+
+	// Inbound TCP connection denied from 1.2.3.4/5555 to 10.0.0.1/443 flags SYN on interface outside
+	// Deny inbound UDP from 1.2.3.4/5555 to 10.0.0.1/53 on interface outside
+	// Deny TCP (no connection) from 1.2.3.4/5555 to 10.0.0.1/443 flags RST on interface outside
+	asaFromToRe = regexp.MustCompile(`(?i)^(?:(Inbound|Outbound)\s+(\w+)\s+connection\s+(denied)|(Deny)\s+(?:inbound\s+|outbound\s+)?(\w+)(?:\s+\(no connection\)|\s+reverse path check)?)\s+from\s+(` + ipPattern + `)(?:/(\d+))?\s+to\s+(` + ipPattern + `)(?:/(\d+))?.*?(?:on interface\s+(\S+))?$`)
+
+	ftdKeyValueRe = regexp.MustCompile(`(\w+)\s*[:=]\s*([^,]*)`)
+)
+
+// asaParser parses the body of an ASA/FTD message following the "%ASA-x-nnnnnn:" header
+type asaParser func(body string) (firewallEvent, bool)
+
+// asaParsers maps ASA/FTD message IDs to the parser understanding their layout
+var asaParsers = map[string]asaParser{
+	"106001": parseAsaFromTo,
+	"106006": parseAsaFromTo,
+	"106007": parseAsaFromTo,
+	"106010": parseAsaSrcDst,
+	"106014": parseAsaSrcDst,
+	"106015": parseAsaFromTo,
+	"106021": parseAsaFromTo,
+	"106023": parseAsaSrcDst,
+	"106100": parseAsaAccessList,
+	"302013": parseAsaConnection,
+	"302014": parseAsaConnection,
+	"302015": parseAsaConnection,
+	"302016": parseAsaConnection,
+	"302020": parseAsaIcmpConnection,
+	"302021": parseAsaIcmpConnection,
+	"430002": parseFtdUnifiedEvent,
+	"430003": parseFtdUnifiedEvent,
+	"430004": parseFtdUnifiedEvent,
+	"430005": parseFtdUnifiedEvent,
+}
+
+// extractCiscoAsa parses Cisco ASA and FTD messages based on their message ID
+func extractCiscoAsa(msg string) (firewallEvent, bool) {
+	header := asaHeaderRe.FindStringSubmatch(msg)
+	if len(header) < 3 {
+		return firewallEvent{}, false
+	}
+
+	messageID := header[1]
+	parser, ok := asaParsers[messageID]
+	if !ok {
+		zap.L().Debug("Unsupported Cisco ASA/FTD message ID",
+			zap.String("messageID", messageID),
+			zap.String("msg", msg),
+		)
+		return firewallEvent{}, false
+	}
+
+	event, ok := parser(strings.TrimSpace(header[2]))
+	if !ok {
+		zap.L().Debug("Failed to parse Cisco ASA/FTD message",
+			zap.String("messageID", messageID),
+			zap.String("msg", msg),
+		)
+		return firewallEvent{}, false
+	}
+	event.MessageID = messageID
+
+	zap.L().Debug("Extracted Cisco ASA/FTD event",
+		zap.String("messageID", event.MessageID),
+		zap.String("src", event.Src),
+		zap.String("dst", event.Dst),
+		zap.Uint16("srcPort", event.SrcPort),
+		zap.Uint16("dstPort", event.DstPort),
+		zap.String("protocol", event.Protocol),
+		zap.String("action", event.Action),
+		zap.String("srcInterface", event.SrcInterface),
+		zap.String("dstInterface", event.DstInterface),
+	)
+	return event, true
+}
+
+func parseAsaConnection(body string) (firewallEvent, bool) {
+	m := asaConnRe.FindStringSubmatch(body)
+	if m == nil {
+		return firewallEvent{}, false
+	}
+
+	// The "for" side is always the foreign (lower security) side of the connection,
+	// so for outbound connections the initiator is the "to" side.
+	event := firewallEvent{
+		Protocol:     strings.ToLower(m[3]),
+		Action:       types.ActionAllow,
+		SrcInterface: m[4],
+		Src:          m[5],
+		SrcPort:      parsePort(m[6]),
+		DstInterface: m[7],
+		Dst:          m[8],
+		DstPort:      parsePort(m[9]),
+	}
+	if strings.EqualFold(m[2], "outbound") {
+		event = swapDirection(event)
+	}
+	return event, true
+}
+
+func parseAsaIcmpConnection(body string) (firewallEvent, bool) {
+	m := asaIcmpConnRe.FindStringSubmatch(body)
+	if m == nil {
+		return firewallEvent{}, false
+	}
+
+	event := firewallEvent{
+		Protocol: strings.ToLower(m[3]),
+		Action:   types.ActionAllow,
+		Src:      m[4],
+		Dst:      m[5],
+	}
+	// Teardowns carry no direction, the foreign address is taken as the source as for inbound
+	if strings.EqualFold(m[2], "outbound") {
+		event = swapDirection(event)
+	}
+	return event, true
+}
+
+func parseAsaSrcDst(body string) (firewallEvent, bool) {
+	m := asaSrcDstRe.FindStringSubmatch(body)
+	if m == nil {
+		return firewallEvent{}, false
+	}
+
+	return firewallEvent{
+		Action:       normalizeAction(m[1]),
+		Protocol:     strings.ToLower(m[2]),
+		SrcInterface: m[3],
+		Src:          m[4],
+		SrcPort:      parsePort(m[5]),
+		DstInterface: m[6],
+		Dst:          m[7],
+		DstPort:      parsePort(m[8]),
+	}, true
+}
+
+func parseAsaAccessList(body string) (firewallEvent, bool) {
+	m := asaAccessListRe.FindStringSubmatch(body)
+	if m == nil {
+		return firewallEvent{}, false
+	}
+
+	return firewallEvent{
+		Action:       normalizeAction(m[1]),
+		Protocol:     strings.ToLower(m[2]),
+		SrcInterface: m[3],
+		Src:          m[4],
+		SrcPort:      parsePort(m[5]),
+		DstInterface: m[6],
+		Dst:          m[7],
+		DstPort:      parsePort(m[8]),
+	}, true
+}
+
+func parseAsaFromTo(body string) (firewallEvent, bool) {
+	m := asaFromToRe.FindStringSubmatch(body)
+	if m == nil {
+		return firewallEvent{}, false
+	}
+
+	protocol := m[2]
+	if protocol == "" {
+		protocol = m[5]
+	}
+
+	return firewallEvent{
+		Action:       types.ActionDeny,
+		Protocol:     strings.ToLower(protocol),
+		Src:          m[6],
+		SrcPort:      parsePort(m[7]),
+		Dst:          m[8],
+		DstPort:      parsePort(m[9]),
+		SrcInterface: m[10],
+	}, true
+}
+
+// parseFtdUnifiedEvent parses FTD connection events sent as comma separated
+// "Key: Value" (or "Key=Value") pairs, e.g.
+// "AccessControlRuleAction: Block, SrcIP: 1.2.3.4, DstIP: 10.0.0.1, SrcPort: 5555, DstPort: 443, Protocol: tcp, ..."
+func parseFtdUnifiedEvent(body string) (firewallEvent, bool) {
+	fields := parseFtdKeyValues(body)
+
+	event := firewallEvent{
+		Src:          fields["SrcIP"],
+		Dst:          fields["DstIP"],
+		SrcPort:      parsePort(fields["SrcPort"]),
+		DstPort:      parsePort(fields["DstPort"]),
+		Protocol:     strings.ToLower(fields["Protocol"]),
+		Action:       normalizeAction(fields["AccessControlRuleAction"]),
+		SrcInterface: fields["IngressInterface"],
+		DstInterface: fields["EgressInterface"],
+	}
+	if event.Src == "" || event.Dst == "" {
+		return firewallEvent{}, false
+	}
+	return event, true
+}
+
+func parseFtdKeyValues(body string) map[string]string {
+	fields := make(map[string]string)
+	for _, m := range ftdKeyValueRe.FindAllStringSubmatch(body, -1) {
+		if _, exists := fields[m[1]]; !exists {
+			fields[m[1]] = strings.TrimSpace(m[2])
+		}
+	}
+	return fields
+}
+
+func swapDirection(event firewallEvent) firewallEvent {
+	event.Src, event.Dst = event.Dst, event.Src
+	event.SrcPort, event.DstPort = event.DstPort, event.SrcPort
+	event.SrcInterface, event.DstInterface = event.DstInterface, event.SrcInterface
+	return event
+}
+
+func parsePort(s string) uint16 {
+	port, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(port)
+}
@@ -0,0 +1,168 @@
+package syslog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Reasons why no IPs could be extracted from a message
+const (
+	FailureNoMessage        = "no-message-field"
+	FailureFewerThanTwoIPs  = "fewer-than-two-ips"
+	FailureFilteredReserved = "filtered-reserved"
+	FailureNoSecurityEvent  = "no-security-event"
+)
+
+const (
+	// maxFailureSenders bounds the per-sender store, further senders are counted under "other"
+	maxFailureSenders   = 256
+	maxFailureSamples   = 5
+	maxFailureSampleLen = 1024
+)
+
+// ParseFailureSample is an unparsed message kept for diagnostics
+type ParseFailureSample struct {
+	Time    time.Time `json:"time"`
+	Reason  string    `json:"reason"`
+	Parser  string    `json:"parser,omitempty"`
+	Message string    `json:"message"`
+}
+
+// SenderParseFailures holds the failure counts and latest samples of one sender
+type SenderParseFailures struct {
+	Sender   string               `json:"sender"`
+	Total    uint64               `json:"total"`
+	ByReason map[string]uint64    `json:"byReason"`
+	Samples  []ParseFailureSample `json:"samples"`
+}
+
+// ParseFailureReport is a snapshot of the parse failures since start or the last reset
+type ParseFailureReport struct {
+	Since    time.Time             `json:"since"`
+	Total    uint64                `json:"total"`
+	ByReason map[string]uint64     `json:"byReason"`
+	Senders  []SenderParseFailures `json:"senders"`
+}
+
+var (
+	parseFailures      = make(map[string]*SenderParseFailures)
+	parseFailuresSince = time.Now()
+	parseFailuresMutex sync.Mutex
+)
+
+// recordParseFailure counts a message no IPs could be extracted from and keeps it as a sample
+func recordParseFailure(sender, reason, parser, message string) {
+	if sender == "" {
+		sender = "unknown"
+	}
+	if len(message) > maxFailureSampleLen {
+		message = message[:maxFailureSampleLen]
+	}
+
+	parseFailuresMutex.Lock()
+	defer parseFailuresMutex.Unlock()
+
+	entry, ok := parseFailures[sender]
+	if !ok {
+		if len(parseFailures) >= maxFailureSenders {
+			sender = "other"
+			entry = parseFailures[sender]
+		}
+		if entry == nil {
+			entry = &SenderParseFailures{Sender: sender, ByReason: make(map[string]uint64)}
+			parseFailures[sender] = entry
+		}
+	}
+
+	entry.Total++
+	entry.ByReason[reason]++
+	// Keep the latest samples
+	if len(entry.Samples) >= maxFailureSamples {
+		entry.Samples = entry.Samples[1:]
+	}
+	entry.Samples = append(entry.Samples, ParseFailureSample{
+		Time:    time.Now(),
+		Reason:  reason,
+		Parser:  parser,
+		Message: message,
+	})
+}
+
+// ParseFailures returns a report of the parse failures, senders with the most failures first
+func ParseFailures() ParseFailureReport {
+	parseFailuresMutex.Lock()
+	defer parseFailuresMutex.Unlock()
+
+	report := ParseFailureReport{
+		Since:    parseFailuresSince,
+		ByReason: make(map[string]uint64),
+		Senders:  make([]SenderParseFailures, 0, len(parseFailures)),
+	}
+	for _, entry := range parseFailures {
+		snapshot := SenderParseFailures{
+			Sender:   entry.Sender,
+			Total:    entry.Total,
+			ByReason: make(map[string]uint64, len(entry.ByReason)),
+			Samples:  append([]ParseFailureSample(nil), entry.Samples...),
+		}
+		for reason, count := range entry.ByReason {
+			snapshot.ByReason[reason] = count
+			report.ByReason[reason] += count
+		}
+		report.Total += entry.Total
+		report.Senders = append(report.Senders, snapshot)
+	}
+	sort.Slice(report.Senders, func(i, j int) bool {
+		if report.Senders[i].Total != report.Senders[j].Total {
+			return report.Senders[i].Total > report.Senders[j].Total
+		}
+		return report.Senders[i].Sender < report.Senders[j].Sender
+	})
+	return report
+}
+
+// ResetParseFailures clears all counts and samples
+func ResetParseFailures() {
+	parseFailuresMutex.Lock()
+	parseFailures = make(map[string]*SenderParseFailures)
+	parseFailuresSince = time.Now()
+	parseFailuresMutex.Unlock()
+}
+
+// WriteParseFailureReport prints the report as tables, followed by the samples of each sender
+func WriteParseFailureReport(w io.Writer, report ParseFailureReport) error {
+	fmt.Fprintf(w, "Parse failures since %s: %d\n\n", report.Since.Format(time.RFC3339), report.Total)
+	if report.Total == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SENDER\tTOTAL\t"+strings.ToUpper(strings.Join(failureReasons(), "\t")))
+	for _, sender := range report.Senders {
+		row := []string{sender.Sender, fmt.Sprint(sender.Total)}
+		for _, reason := range failureReasons() {
+			row = append(row, fmt.Sprint(sender.ByReason[reason]))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, sender := range report.Senders {
+		fmt.Fprintf(w, "\nSamples from %s:\n", sender.Sender)
+		for _, sample := range sender.Samples {
+			fmt.Fprintf(w, "  %s  %-20s %s\n", sample.Time.Format(time.RFC3339), sample.Reason, sample.Message)
+		}
+	}
+	return nil
+}
+
+func failureReasons() []string {
+	return []string{FailureNoMessage, FailureFewerThanTwoIPs, FailureFilteredReserved, FailureNoSecurityEvent}
+}
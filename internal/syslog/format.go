@@ -0,0 +1,39 @@
+package syslog
+
+import (
+	"bufio"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// rawFormat wraps a go-syslog format so the unparsed line is kept in logParts["raw"],
+// which is needed to forward messages unchanged. go-syslog only fills an empty
+// hostname from the client address for its own format values, listenerHandler does it instead.
+type rawFormat struct {
+	format.Format
+	// newSplit overrides the framing of the wrapped format if set. It is
+	// called per connection (and per datagram), so split state is not shared.
+	newSplit func() bufio.SplitFunc
+}
+
+type rawParser struct {
+	format.LogParser
+	raw string
+}
+
+func (f *rawFormat) GetParser(line []byte) format.LogParser {
+	return &rawParser{LogParser: f.Format.GetParser(line), raw: string(line)}
+}
+
+func (f *rawFormat) GetSplitFunc() bufio.SplitFunc {
+	if f.newSplit != nil {
+		return f.newSplit()
+	}
+	return f.Format.GetSplitFunc()
+}
+
+func (p *rawParser) Dump() format.LogParts {
+	logParts := p.LogParser.Dump()
+	logParts["raw"] = p.raw
+	return logParts
+}
@@ -0,0 +1,464 @@
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/recommender"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultForwardBufferSize = 10000
+	forwardDialTimeout       = 10 * time.Second
+	forwardWriteTimeout      = 10 * time.Second
+	forwardMaxBackoff        = 30 * time.Second
+
+	// verdictSDID identifies the structured-data element added to forwarded messages
+	verdictSDID = "nfg@32473"
+)
+
+// Framing modes for stream listeners and forwarding targets. Only listeners can auto-detect.
+const (
+	FramingAuto          = "auto"
+	FramingLF            = "lf"
+	FramingOctetCounting = "octet-counting"
+)
+
+// rfc5424HeaderRe matches the RFC 5424 header up to the start of STRUCTURED-DATA
+var rfc5424HeaderRe = regexp.MustCompile(`^<\d{1,3}>\d{1,2} \S+ \S+ \S+ \S+ \S+ `)
+
+// ForwardFilter restricts which messages are relayed to a target. Empty fields match everything.
+type ForwardFilter struct {
+	Senders  []string `json:"senders"`
+	Programs []string `json:"programs"`
+	// OnlyMatched relays only messages from which IPs to evaluate were extracted
+	OnlyMatched bool `json:"onlyMatched"`
+	// MinScore relays only messages where an extracted IP has at least this NFG score
+	MinScore int32 `json:"minScore"`
+}
+
+// ForwardTarget is a downstream syslog receiver such as a SIEM
+type ForwardTarget struct {
+	Name string `json:"name"`
+	// Address is protocol://host:port with protocol udp, tcp or tls
+	Address string `json:"address"`
+	// Framing is lf (default) or octet-counting, ignored for udp
+	Framing            string `json:"framing"`
+	CAFile             string `json:"caFile"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	// BufferSize is the number of messages kept while the target is down, oldest are dropped first
+	BufferSize int `json:"bufferSize"`
+	// AddVerdict adds a structured-data element with the sensor's verdict to each message
+	AddVerdict bool          `json:"addVerdict"`
+	Filter     ForwardFilter `json:"filter"`
+}
+
+type forwardFile struct {
+	Targets []ForwardTarget `json:"targets"`
+}
+
+// verdictFunc returns the NFG score and matched blocklists of an IP
+type verdictFunc func(ip string) (score int32, blocklists []string)
+
+// shouldBlockVerdict is the verdict of the recommender engine
+func shouldBlockVerdict(ip string) (int32, []string) {
+	decisions, score := recommender.ShouldBlock(ip)
+	var blocklists []string
+	for _, decision := range decisions {
+		if decision.Block {
+			blocklists = append(blocklists, decision.Blocklist)
+		}
+	}
+	return score, blocklists
+}
+
+type forwardItem struct {
+	raw string
+	src string
+	dst string
+}
+
+type forwardTarget struct {
+	ForwardTarget
+	network    string
+	addr       string
+	tlsConfig  *tls.Config
+	senderNets []*net.IPNet
+
+	queue     chan forwardItem
+	conn      net.Conn
+	forwarded atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// Forwarder relays received messages to downstream syslog targets
+type Forwarder struct {
+	targets []*forwardTarget
+	verdict verdictFunc
+}
+
+// NewForwarder loads the forwarding targets. An empty path yields a nil Forwarder, which forwards nothing.
+func NewForwarder(path string, verdict verdictFunc) (*Forwarder, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read syslog forward file: %w", err)
+	}
+	var file forwardFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode syslog forward file: %w", err)
+	}
+
+	f := &Forwarder{verdict: verdict}
+	for _, target := range file.Targets {
+		t, err := newForwardTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		f.targets = append(f.targets, t)
+	}
+
+	zap.L().Info("Loaded syslog forwarding targets",
+		zap.String("file", path),
+		zap.Int("count", len(f.targets)),
+	)
+	return f, nil
+}
+
+func newForwardTarget(target ForwardTarget) (*forwardTarget, error) {
+	if target.Name == "" {
+		target.Name = target.Address
+	}
+
+	u, err := url.Parse(target.Address)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("forward target %q: invalid address %q, expected protocol://host:port", target.Name, target.Address)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("forward target %q: %w", target.Name, err)
+	}
+
+	t := &forwardTarget{addr: u.Host}
+	switch u.Scheme {
+	case "udp":
+		t.network = "udp"
+	case "tcp":
+		t.network = "tcp"
+	case "tls":
+		t.network = "tcp"
+		t.tlsConfig = &tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: target.InsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		}
+		if target.CAFile != "" {
+			pem, err := os.ReadFile(target.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("forward target %q: failed to read CA file: %w", target.Name, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("forward target %q: no certificates found in CA file", target.Name)
+			}
+			t.tlsConfig.RootCAs = pool
+		}
+	default:
+		return nil, fmt.Errorf("forward target %q: unsupported protocol %q", target.Name, u.Scheme)
+	}
+
+	switch target.Framing {
+	case "":
+		target.Framing = FramingLF
+	case FramingLF, FramingOctetCounting:
+	default:
+		return nil, fmt.Errorf("forward target %q: unknown framing %q", target.Name, target.Framing)
+	}
+
+	if target.BufferSize <= 0 {
+		target.BufferSize = defaultForwardBufferSize
+	}
+
+	for _, cidr := range target.Filter.Senders {
+		network, err := parseSenderCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("forward target %q: %w", target.Name, err)
+		}
+		t.senderNets = append(t.senderNets, network)
+	}
+
+	t.ForwardTarget = target
+	t.queue = make(chan forwardItem, target.BufferSize)
+	return t, nil
+}
+
+// Start runs one delivery loop per target until ctx is done
+func (f *Forwarder) Start(ctx context.Context) {
+	if f == nil {
+		return
+	}
+	for _, t := range f.targets {
+		zap.L().Info("Forwarding syslog messages",
+			zap.String("target", t.Name),
+			zap.String("address", t.Address),
+			zap.Bool("addVerdict", t.AddVerdict),
+		)
+		go t.run(ctx, f.verdict)
+	}
+}
+
+// Forward queues the unchanged message for every target whose filter matches. It never blocks.
+func (f *Forwarder) Forward(logParts map[string]interface{}, parsed parsedMessage) {
+	if f == nil {
+		return
+	}
+	raw, ok := logParts["raw"].(string)
+	if !ok || raw == "" {
+		return
+	}
+
+	program := programFromLogParts(logParts)
+	item := forwardItem{raw: raw, src: parsed.Src, dst: parsed.Dst}
+	for _, t := range f.targets {
+		if t.matches(parsed, program) {
+			t.enqueue(item)
+		}
+	}
+}
+
+// logStats logs per target counters if messages were dropped
+func (f *Forwarder) logStats() {
+	if f == nil {
+		return
+	}
+	for _, t := range f.targets {
+		dropped := t.dropped.Load()
+		if dropped == 0 {
+			continue
+		}
+		zap.L().Warn("Syslog forwarding target dropped messages",
+			zap.String("target", t.Name),
+			zap.Uint64("forwarded", t.forwarded.Load()),
+			zap.Uint64("dropped", dropped),
+			zap.Int("buffered", len(t.queue)),
+		)
+	}
+}
+
+// matches applies the sender, program and extraction filters, MinScore is checked on delivery
+func (t *forwardTarget) matches(parsed parsedMessage, program string) bool {
+	if t.Filter.OnlyMatched && parsed.Src == "" {
+		return false
+	}
+
+	if len(t.Filter.Programs) > 0 {
+		found := false
+		for _, p := range t.Filter.Programs {
+			if strings.EqualFold(p, program) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(t.senderNets) > 0 {
+		ip := net.ParseIP(parsed.Sender)
+		if ip == nil {
+			return false
+		}
+		for _, network := range t.senderNets {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// enqueue adds the item to the buffer, dropping the oldest message if it is full
+func (t *forwardTarget) enqueue(item forwardItem) {
+	for {
+		select {
+		case t.queue <- item:
+			return
+		default:
+		}
+		select {
+		case <-t.queue:
+			t.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+func (t *forwardTarget) run(ctx context.Context, verdict verdictFunc) {
+	defer t.disconnect()
+
+	backoff := time.Second
+	down := false
+	var pending []byte
+
+	for {
+		if pending == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case item := <-t.queue:
+				pending = t.render(item, verdict)
+				if pending == nil {
+					continue
+				}
+			}
+		}
+
+		if t.conn == nil {
+			if err := t.connect(); err != nil {
+				if !down {
+					zap.L().Warn("Syslog forwarding target unreachable, buffering messages",
+						zap.String("target", t.Name),
+						zap.Error(err),
+					)
+					down = true
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff = min(backoff*2, forwardMaxBackoff)
+				continue
+			}
+			if down {
+				zap.L().Info("Syslog forwarding target reachable again",
+					zap.String("target", t.Name),
+					zap.Int("buffered", len(t.queue)),
+				)
+				down = false
+			}
+			backoff = time.Second
+		}
+
+		if err := t.write(pending); err != nil {
+			zap.L().Warn("Failed to forward syslog message, reconnecting",
+				zap.String("target", t.Name),
+				zap.Error(err),
+			)
+			t.disconnect()
+			continue
+		}
+		t.forwarded.Add(1)
+		pending = nil
+	}
+}
+
+func (t *forwardTarget) connect() error {
+	dialer := &net.Dialer{Timeout: forwardDialTimeout}
+	var conn net.Conn
+	var err error
+	if t.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, t.network, t.addr, t.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(t.network, t.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", t.Address, err)
+	}
+	t.conn = conn
+	return nil
+}
+
+func (t *forwardTarget) disconnect() {
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
+
+func (t *forwardTarget) write(msg []byte) error {
+	if err := t.conn.SetWriteDeadline(time.Now().Add(forwardWriteTimeout)); err != nil {
+		return err
+	}
+
+	var frame []byte
+	switch {
+	case t.network == "udp":
+		frame = msg
+	case t.Framing == FramingOctetCounting:
+		frame = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	default:
+		frame = append(msg, '\n')
+	}
+	_, err := t.conn.Write(frame)
+	return err
+}
+
+// render applies the MinScore filter and adds the verdict if configured. It returns nil if the message is filtered.
+func (t *forwardTarget) render(item forwardItem, verdict verdictFunc) []byte {
+	if !t.AddVerdict && t.Filter.MinScore <= 0 {
+		return []byte(item.raw)
+	}
+
+	var params []string
+	maxScore := int32(-1)
+	for _, side := range []struct{ name, ip string }{{"src", item.src}, {"dst", item.dst}} {
+		if side.ip == "" {
+			continue
+		}
+		score, blocklists := verdict(side.ip)
+		maxScore = max(maxScore, score)
+		params = append(params,
+			sdParam(side.name, side.ip),
+			sdParam(side.name+"Score", strconv.Itoa(int(score))),
+		)
+		if len(blocklists) > 0 {
+			params = append(params, sdParam(side.name+"Blocklists", strings.Join(blocklists, ",")))
+		}
+	}
+
+	if t.Filter.MinScore > 0 && maxScore < t.Filter.MinScore {
+		return nil
+	}
+	if !t.AddVerdict || len(params) == 0 {
+		return []byte(item.raw)
+	}
+	element := "[" + verdictSDID + " " + strings.Join(params, " ") + "]"
+	return []byte(addStructuredData(item.raw, element))
+}
+
+// addStructuredData inserts an SD-ELEMENT into an RFC 5424 message.
+// Messages in other formats have no structured data, the element is appended to them.
+func addStructuredData(raw, element string) string {
+	loc := rfc5424HeaderRe.FindStringIndex(raw)
+	if loc == nil {
+		return raw + " " + element
+	}
+	rest := raw[loc[1]:]
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	}
+	return raw[:loc[1]] + element + rest
+}
+
+// sdParam formats an SD-PARAM, escaping the characters RFC 5424 requires
+func sdParam(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+	return name + `="` + value + `"`
+}
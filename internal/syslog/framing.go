@@ -0,0 +1,209 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxMessageSize = 64 * 1024
+	// maxOctetCountDigits bounds the MSG-LEN header of octet-counted frames
+	maxOctetCountDigits = 10
+	// frameSampleEvery logs every n-th bad frame per listener and reason
+	frameSampleEvery = 100
+	frameSampleBytes = 256
+)
+
+// framingStats counts frames of a listener which could not be received intact
+type framingStats struct {
+	listener  string
+	truncated atomic.Uint64
+	malformed atomic.Uint64
+}
+
+func newFramingStats(listener string) *framingStats {
+	return &framingStats{listener: listener}
+}
+
+// record counts a bad frame and samples it to the debug log
+func (s *framingStats) record(reason string, counter *atomic.Uint64, frame []byte, detail string) {
+	count := counter.Add(1)
+	if count%frameSampleEvery != 1 {
+		return
+	}
+	if len(frame) > frameSampleBytes {
+		frame = frame[:frameSampleBytes]
+	}
+	zap.L().Debug("Sampled bad syslog frame",
+		zap.String("listener", s.listener),
+		zap.String("reason", reason),
+		zap.String("detail", detail),
+		zap.Uint64("count", count),
+		zap.ByteString("sample", frame),
+	)
+}
+
+// log reports the counters if any frame was truncated or malformed
+func (s *framingStats) log() {
+	truncated, malformed := s.truncated.Load(), s.malformed.Load()
+	if truncated == 0 && malformed == 0 {
+		return
+	}
+	zap.L().Warn("Syslog listener received bad frames, check the sender's framing and message size",
+		zap.String("listener", s.listener),
+		zap.Uint64("truncated", truncated),
+		zap.Uint64("malformed", malformed),
+	)
+}
+
+// frameSplitter splits a stream into frames. It keeps the frame in progress itself
+// and always consumes its input, so frames are not limited by the bufio.Scanner buffer.
+// A new splitter is used for every connection.
+type frameSplitter struct {
+	framing string
+	maxSize int
+	stats   *framingStats
+
+	frame     []byte
+	inFrame   bool
+	octets    bool
+	remaining int
+	truncated int
+}
+
+func newFrameSplitter(framing string, maxSize int, stats *framingStats) *frameSplitter {
+	return &frameSplitter{framing: framing, maxSize: maxSize, stats: stats}
+}
+
+func (s *frameSplitter) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// bufio.Scanner stops at EOF once a call yields no token, so keep
+	// stepping over headers and empty frames until a frame is complete
+	for {
+		n, token, err := s.step(data[advance:], atEOF)
+		advance += n
+		if token != nil || err != nil || n == 0 || (advance == len(data) && !atEOF) {
+			return advance, token, err
+		}
+	}
+}
+
+func (s *frameSplitter) step(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if !s.inFrame {
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+		header, ok := s.begin(data, atEOF)
+		if !ok {
+			return 0, nil, nil
+		}
+		if header > 0 {
+			return header, nil, nil
+		}
+	}
+
+	if s.octets {
+		n := min(s.remaining, len(data))
+		s.appendFrame(data[:n])
+		s.remaining -= n
+		if s.remaining == 0 {
+			return n, s.finish(), nil
+		}
+		if atEOF {
+			s.stats.record("malformed", &s.stats.malformed, s.frame, "connection closed inside octet-counted frame")
+			s.reset()
+			return len(data), nil, bufio.ErrFinalToken
+		}
+		return n, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		s.appendFrame(bytes.TrimSuffix(data[:i], []byte{'\r'}))
+		return i + 1, s.finish(), nil
+	}
+	s.appendFrame(data)
+	if atEOF {
+		// The last frame of a connection needs no trailing LF
+		return len(data), s.finish(), nil
+	}
+	return len(data), nil, nil
+}
+
+// begin starts a new frame. It returns the number of header bytes consumed and
+// false if more data is needed to tell the framing apart.
+func (s *frameSplitter) begin(data []byte, atEOF bool) (int, bool) {
+	s.inFrame = true
+	s.octets = false
+
+	if s.framing == FramingLF || (s.framing == FramingAuto && (data[0] < '0' || data[0] > '9')) {
+		return 0, true
+	}
+
+	i := bytes.IndexByte(data, ' ')
+	if i < 0 && len(data) <= maxOctetCountDigits && !atEOF {
+		s.inFrame = false
+		return 0, false
+	}
+	if i > 0 && i <= maxOctetCountDigits {
+		if length, err := strconv.Atoi(string(data[:i])); err == nil && length > 0 {
+			s.octets = true
+			s.remaining = length
+			return i + 1, true
+		}
+	}
+
+	// Without a valid MSG-LEN the frame is read up to the next LF to resynchronise
+	end := len(data)
+	if j := bytes.IndexByte(data, '\n'); j >= 0 {
+		end = j
+	}
+	s.stats.record("malformed", &s.stats.malformed, data[:end], "invalid octet count")
+	return 0, true
+}
+
+// appendFrame adds data to the frame in progress, dropping everything beyond maxSize
+func (s *frameSplitter) appendFrame(data []byte) {
+	room := s.maxSize - len(s.frame)
+	if room < len(data) {
+		s.truncated += len(data) - max(room, 0)
+		data = data[:max(room, 0)]
+	}
+	s.frame = append(s.frame, data...)
+}
+
+// finish returns the completed frame, nil for empty frames
+func (s *frameSplitter) finish() []byte {
+	frame := s.frame
+	if s.truncated > 0 {
+		s.stats.record("truncated", &s.stats.truncated, frame,
+			strconv.Itoa(s.truncated)+" bytes beyond maximum message size dropped")
+	}
+	s.reset()
+	if len(frame) == 0 {
+		return nil
+	}
+	return frame
+}
+
+func (s *frameSplitter) reset() {
+	s.frame = nil
+	s.inFrame = false
+	s.remaining = 0
+	s.truncated = 0
+}
+
+// datagramSplit returns a whole datagram as one frame, truncated to maxSize
+func datagramSplit(maxSize int, stats *framingStats) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		frame := bytes.TrimRight(data, "\r\n")
+		if len(frame) > maxSize {
+			stats.record("truncated", &stats.truncated, frame,
+				strconv.Itoa(len(frame)-maxSize)+" bytes beyond maximum message size dropped")
+			frame = frame[:maxSize]
+		}
+		return len(data), frame, nil
+	}
+}
@@ -0,0 +1,398 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"go.uber.org/zap"
+)
+
+// ProtocolGELF marks messages received by the GELF listener
+const ProtocolGELF = "gelf"
+
+const (
+	// maxGELFMessageSize bounds a decompressed message, the chunked maximum is 128 chunks of 8 KiB
+	maxGELFMessageSize = 1024 * 1024
+	maxGELFChunks      = 128
+	// maxGELFChunkSize bounds a chunk datagram including its header
+	maxGELFChunkSize = 8192
+	// gelfChunkTimeout is how long the chunks of a message are kept, as in the GELF specification
+	gelfChunkTimeout = 5 * time.Second
+	// maxPendingGELFMessages and maxPendingGELFBytes bound the messages being reassembled
+	maxPendingGELFMessages = 1000
+	maxPendingGELFBytes    = 32 * 1024 * 1024
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// gelfChunks collects the chunks of one message
+type gelfChunks struct {
+	first    time.Time
+	parts    [][]byte
+	received int
+	size     int
+}
+
+// gelfInput receives GELF messages over UDP (chunked, compressed) and TCP (null byte delimited)
+type gelfInput struct {
+	listener *SyslogListener
+	channel  chan<- receivedMessage
+	senders  *SenderRegistry
+
+	mu      sync.Mutex
+	pending map[string]*gelfChunks
+	// pendingBytes is the size of all pending chunks
+	pendingBytes int
+
+	invalid    atomic.Uint64
+	incomplete atomic.Uint64
+}
+
+// newGELFInput returns nil if no GELF port is configured
+func newGELFInput(cfg *config.Config, channel chan<- receivedMessage, senders *SenderRegistry) (*gelfInput, error) {
+	if cfg.GelfPort == 0 {
+		return nil, nil
+	}
+	listener := &SyslogListener{
+		Name:     ProtocolGELF,
+		Protocol: ProtocolGELF,
+		Address:  cfg.SyslogListenAddr,
+		Port:     cfg.GelfPort,
+		Parser:   cfg.GelfParser,
+	}
+	if listener.Port < 0 || listener.Port > 65535 {
+		return nil, fmt.Errorf("GELF listener: invalid port %d", listener.Port)
+	}
+	if listener.Parser != "" && !isKnownParser(listener.Parser) {
+		return nil, fmt.Errorf("GELF listener: unknown parser %q", listener.Parser)
+	}
+	return &gelfInput{
+		listener: listener,
+		channel:  channel,
+		senders:  senders,
+		pending:  make(map[string]*gelfChunks),
+	}, nil
+}
+
+// serve listens on UDP and TCP until ctx is done
+func (g *gelfInput) serve(ctx context.Context) error {
+	udpConn, err := net.ListenPacket("udp", g.listener.Endpoint())
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP: %w", err)
+	}
+	tcpListener, err := net.Listen("tcp", g.listener.Endpoint())
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("failed to listen on TCP: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		udpConn.Close()
+		tcpListener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		g.serveUDP(ctx, udpConn)
+	}()
+	go func() {
+		defer wg.Done()
+		g.serveTCP(ctx, tcpListener)
+	}()
+	wg.Wait()
+	return nil
+}
+
+func (g *gelfInput) serveUDP(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				zap.L().Error("GELF UDP listener failed", zap.Error(err))
+			}
+			return
+		}
+		// Rejected before reassembly, so that unknown senders cannot hold chunks
+		if !g.senders.IsAllowed(senderFromLogParts(map[string]interface{}{"client": addr})) {
+			continue
+		}
+		payload := g.reassemble(buf[:n])
+		if payload == nil {
+			continue
+		}
+		g.handle(ctx, payload, addr)
+	}
+}
+
+func (g *gelfInput) serveTCP(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				zap.L().Error("GELF TCP listener failed", zap.Error(err))
+			}
+			return
+		}
+		if !g.senders.IsAllowed(senderFromLogParts(map[string]interface{}{"client": conn.RemoteAddr()})) {
+			conn.Close()
+			continue
+		}
+		go g.serveConn(ctx, conn)
+	}
+}
+
+// serveConn reads null byte delimited, uncompressed messages
+func (g *gelfInput) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxGELFMessageSize)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		payload := bytes.TrimSpace(scanner.Bytes())
+		if len(payload) == 0 {
+			continue
+		}
+		g.handle(ctx, append([]byte(nil), payload...), conn.RemoteAddr())
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+		g.invalid.Add(1)
+		zap.L().Debug("GELF TCP connection closed",
+			zap.String("remote", conn.RemoteAddr().String()),
+			zap.Error(err),
+		)
+	}
+}
+
+// reassemble returns the payload of a datagram, or nil while chunks are missing
+func (g *gelfInput) reassemble(datagram []byte) []byte {
+	if !bytes.HasPrefix(datagram, gelfChunkMagic) {
+		return append([]byte(nil), datagram...)
+	}
+	// Magic bytes, 8 byte message ID, sequence number and sequence count
+	if len(datagram) < 12 || len(datagram) > maxGELFChunkSize {
+		g.invalid.Add(1)
+		return nil
+	}
+	id := string(datagram[2:10])
+	seq, count := int(datagram[10]), int(datagram[11])
+	if count == 0 || count > maxGELFChunks || seq >= count {
+		g.invalid.Add(1)
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.expire(now)
+	msg, ok := g.pending[id]
+	if !ok {
+		if len(g.pending) >= maxPendingGELFMessages {
+			g.incomplete.Add(1)
+			return nil
+		}
+		msg = &gelfChunks{first: now, parts: make([][]byte, count)}
+		g.pending[id] = msg
+	}
+	if len(msg.parts) != count {
+		g.invalid.Add(1)
+		g.drop(id, msg)
+		return nil
+	}
+	if msg.parts[seq] == nil {
+		if g.pendingBytes+len(datagram)-12 > maxPendingGELFBytes {
+			g.incomplete.Add(1)
+			g.drop(id, msg)
+			return nil
+		}
+		msg.parts[seq] = append([]byte(nil), datagram[12:]...)
+		msg.received++
+		msg.size += len(msg.parts[seq])
+		g.pendingBytes += len(msg.parts[seq])
+	}
+	if msg.received < count {
+		return nil
+	}
+
+	g.drop(id, msg)
+	return bytes.Join(msg.parts, nil)
+}
+
+// drop removes a pending message, the caller holds mu
+func (g *gelfInput) drop(id string, msg *gelfChunks) {
+	delete(g.pending, id)
+	g.pendingBytes -= msg.size
+}
+
+// expire drops messages whose chunks did not arrive in time, the caller holds mu
+func (g *gelfInput) expire(now time.Time) {
+	for id, msg := range g.pending {
+		if now.Sub(msg.first) > gelfChunkTimeout {
+			g.drop(id, msg)
+			g.incomplete.Add(1)
+		}
+	}
+}
+
+// handle decompresses and decodes a message and passes it to the pipeline
+func (g *gelfInput) handle(ctx context.Context, payload []byte, addr net.Addr) {
+	data, err := decompressGELF(payload)
+	if err != nil {
+		g.invalid.Add(1)
+		zap.L().Debug("Failed to decompress GELF message",
+			zap.String("remote", addr.String()),
+			zap.Error(err),
+		)
+		return
+	}
+	logParts, err := gelfLogParts(data)
+	if err != nil {
+		g.invalid.Add(1)
+		zap.L().Debug("Invalid GELF message",
+			zap.String("remote", addr.String()),
+			zap.Error(err),
+		)
+		return
+	}
+	logParts["client"] = addr.String()
+
+	select {
+	case g.channel <- receivedMessage{logParts: logParts, listener: g.listener}:
+	case <-ctx.Done():
+	}
+}
+
+// logStats logs the counters of invalid and incomplete messages since the last call
+func (g *gelfInput) logStats() {
+	if g == nil {
+		return
+	}
+	invalid, incomplete := g.invalid.Swap(0), g.incomplete.Swap(0)
+	if invalid == 0 && incomplete == 0 {
+		return
+	}
+	g.mu.Lock()
+	pending := len(g.pending)
+	g.mu.Unlock()
+	zap.L().Warn("GELF listener dropped messages",
+		zap.Uint64("invalid", invalid),
+		zap.Uint64("incompleteChunked", incomplete),
+		zap.Int("pendingChunked", pending),
+	)
+}
+
+// decompressGELF detects gzip and zlib payloads by their magic bytes
+func decompressGELF(payload []byte) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) >= 2 && payload[0] == 0x78 && (uint16(payload[0])<<8|uint16(payload[1]))%31 == 0:
+		reader, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		if len(payload) > maxGELFMessageSize {
+			return nil, fmt.Errorf("message exceeds %d bytes", maxGELFMessageSize)
+		}
+		return payload, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxGELFMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxGELFMessageSize {
+		return nil, fmt.Errorf("message exceeds %d bytes", maxGELFMessageSize)
+	}
+	return data, nil
+}
+
+// gelfLogParts converts a GELF message into the fields the syslog parsers read.
+// The message is the short message, additional fields are kept without their "_"
+// prefix under logParts["fields"] for the structured and field parsers.
+func gelfLogParts(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var msg map[string]interface{}
+	if err := decoder.Decode(&msg); err != nil {
+		return nil, err
+	}
+	shortMessage, _ := msg["short_message"].(string)
+	if shortMessage == "" {
+		return nil, fmt.Errorf("missing short_message")
+	}
+
+	logParts := map[string]interface{}{
+		"message": shortMessage,
+		"raw":     string(data),
+	}
+
+	additional := make(map[string]interface{})
+	for key, value := range msg {
+		if name, ok := strings.CutPrefix(key, "_"); ok && name != "id" {
+			additional[name] = value
+		}
+	}
+	if len(additional) > 0 {
+		logParts["fields"] = additional
+	}
+	if host, ok := msg["host"].(string); ok && host != "" {
+		logParts["hostname"] = host
+	}
+	for _, key := range []string{"_application_name", "_program", "_tag", "facility"} {
+		if program, ok := msg[key].(string); ok && program != "" {
+			logParts["app_name"] = program
+			break
+		}
+	}
+	if level, ok := msg["level"].(json.Number); ok {
+		if severity, err := strconv.Atoi(level.String()); err == nil {
+			logParts["severity"] = severity
+		}
+	}
+	if timestamp, ok := msg["timestamp"].(json.Number); ok {
+		if seconds, err := timestamp.Float64(); err == nil && seconds > 0 {
+			logParts["timestamp"] = epochTime(seconds)
+		}
+	}
+	return logParts, nil
+}
+
+// epochTime converts fractional epoch seconds, rounded to microseconds
+func epochTime(seconds float64) time.Time {
+	return time.UnixMicro(int64(math.Round(seconds * 1e6)))
+}
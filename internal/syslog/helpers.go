@@ -3,18 +3,64 @@ package syslog
 import (
 	"encoding/json"
 	"net"
+	"net/netip"
 	"regexp"
 	"strings"
 
 	"go.uber.org/zap"
 )
 
+// Regex fragments matching IP address candidates. The IPv6 fragment is deliberately
+// loose (compressed forms, embedded IPv4, zone IDs); candidates are validated by normalizeIP.
+const (
+	ipv4Pattern = `\d{1,3}(?:\.\d{1,3}){3}`
+	ipv6Pattern = `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:` + ipv4Pattern + `|[0-9A-Fa-f]{1,4})?(?:%[0-9A-Za-z_.\-]+)?`
+	ipPattern   = `(?:` + ipv6Pattern + `|` + ipv4Pattern + `)`
+)
+
+var (
+	cefSrcRe   = regexp.MustCompile(`\bsrc=\[?(` + ipPattern + `)`)
+	cefDstRe   = regexp.MustCompile(`\bdst=\[?(` + ipPattern + `)`)
+	cefSrc6Re  = regexp.MustCompile(`\bc6a2=\[?(` + ipv6Pattern + `)`)
+	cefDst6Re  = regexp.MustCompile(`\bc6a3=\[?(` + ipv6Pattern + `)`)
+	ciscoIosRe = regexp.MustCompile(`(` + ipPattern + `)\(\d+\)\s*->\s*(` + ipPattern + `)\(\d+\)`)
+	anyIPRe    = regexp.MustCompile(ipv6Pattern + `|\b` + ipv4Pattern + `\b`)
+)
+
+// normalizeIP parses an IPv4 or IPv6 address, optionally bracketed and with a zone ID,
+// and returns it in canonical form. IPv4-mapped IPv6 addresses are returned as IPv4.
+func normalizeIP(ipStr string) (string, bool) {
+	ipStr = strings.TrimSpace(ipStr)
+	ipStr = strings.TrimSuffix(strings.TrimPrefix(ipStr, "["), "]")
+
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return "", false
+	}
+	return addr.WithZone("").Unmap().String(), true
+}
+
+// normalizeIPWithPort is like normalizeIP but also accepts "ip:port" and "[ip]:port"
+func normalizeIPWithPort(s string) (string, bool) {
+	if ip, ok := normalizeIP(s); ok {
+		return ip, true
+	}
+	if host, _, err := net.SplitHostPort(strings.TrimSpace(s)); err == nil {
+		return normalizeIP(host)
+	}
+	return "", false
+}
+
 func extractCEFSrcDst(msg string) (src, dst string) {
-	// Match src=IP and dst=IP
-	srcRe := regexp.MustCompile(`src=(\d+\.\d+\.\d+\.\d+)`)
-	dstRe := regexp.MustCompile(`dst=(\d+\.\d+\.\d+\.\d+)`)
-	srcMatch := srcRe.FindStringSubmatch(msg)
-	dstMatch := dstRe.FindStringSubmatch(msg)
+	// Match src=IP and dst=IP, falling back to the CEF IPv6 custom address fields
+	srcMatch := cefSrcRe.FindStringSubmatch(msg)
+	if len(srcMatch) < 2 {
+		srcMatch = cefSrc6Re.FindStringSubmatch(msg)
+	}
+	dstMatch := cefDstRe.FindStringSubmatch(msg)
+	if len(dstMatch) < 2 {
+		dstMatch = cefDst6Re.FindStringSubmatch(msg)
+	}
 	if len(srcMatch) > 1 && len(dstMatch) > 1 {
 		zap.L().Debug("Extracted CEF source and destination",
 			zap.String("src", srcMatch[1]),
@@ -30,9 +76,8 @@ func extractCEFSrcDst(msg string) (src, dst string) {
 }
 
 func extractCiscoIosSrcDst(msg string) (src, dst string) {
-	// Match IP -> IP
-	ciscoRe := regexp.MustCompile(`(\d+\.\d+\.\d+\.\d+)\(\d+\)\s*->\s*(\d+\.\d+\.\d+\.\d+)\(\d+\)`)
-	match := ciscoRe.FindStringSubmatch(msg)
+	// Match IP(port) -> IP(port)
+	match := ciscoIosRe.FindStringSubmatch(msg)
 	if len(match) > 2 {
 		zap.L().Debug("Extracted Cisco source and destination",
 			zap.String("src", match[1]),
@@ -50,25 +95,32 @@ func extractCiscoIosSrcDst(msg string) (src, dst string) {
 func extractPfSrcDst(msg string) (src, dst string) {
 	fields := strings.Split(msg, ",")
 
-	if len(fields) < 19 {
+	if len(fields) < 17 {
 		return "", ""
 	}
 
-	if len(fields) >= 9 {
+	// Field 9 is the IP version; the address fields sit at different offsets for IPv4 and IPv6
+	switch strings.TrimSpace(fields[8]) {
+	case "4":
+		if len(fields) < 20 {
+			return "", ""
+		}
 		src = strings.TrimSpace(fields[18])
 		dst = strings.TrimSpace(fields[19])
+	case "6":
+		src = strings.TrimSpace(fields[15])
+		dst = strings.TrimSpace(fields[16])
 	}
 
 	return src, dst
 }
 
 func extractIPs(msg string) []string {
-	ipRegex := regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
-	potentialIPs := ipRegex.FindAllString(msg, -1)
+	potentialIPs := anyIPRe.FindAllString(msg, -1)
 	var validIPs []string
 	for _, ipStr := range potentialIPs {
-		if net.ParseIP(ipStr) != nil {
-			validIPs = append(validIPs, ipStr)
+		if ip, ok := normalizeIP(ipStr); ok {
+			validIPs = append(validIPs, ip)
 		}
 	}
 	zap.L().Debug("Extracted IPs from message",
@@ -104,12 +156,19 @@ func isReservedOrInvalidIP(ipStr string) bool {
 	return false
 }
 
-// returns valid src and dst, or empty strings if invalid.
+// returns valid src and dst in canonical form, or empty strings if invalid.
 func validateSrcDst(src, dst string) (validSrc, validDst string, srcInvalid, dstInvalid bool) {
 	if src == "" || dst == "" {
 		return "", "", src == "", dst == ""
 	}
 
+	normalizedSrc, srcOk := normalizeIP(src)
+	normalizedDst, dstOk := normalizeIP(dst)
+	if !srcOk || !dstOk {
+		return "", "", !srcOk, !dstOk
+	}
+	src, dst = normalizedSrc, normalizedDst
+
 	if src == dst {
 		return "", "", false, false
 	}
@@ -157,9 +216,8 @@ func extractIPsFromAllFields(msg string) []string {
 			continue
 		}
 
-		// Try to parse as IP
-		if ip := net.ParseIP(field); ip != nil {
-			ipStr := ip.String()
+		// Try to parse as IP, with or without a port
+		if ipStr, ok := normalizeIPWithPort(field); ok {
 			// Avoid duplicates and skip link-local/loopback
 			if !seen[ipStr] {
 				seen[ipStr] = true
@@ -188,16 +246,15 @@ func extractIPsFromJSON(msg string) []string {
 func extractIPsFromValue(v interface{}, ips *[]string, seen map[string]bool) {
 	switch val := v.(type) {
 	case map[string]interface{}:
-		for _, value := range val {
-			extractIPsFromValue(value, ips, seen)
+		for _, key := range sortedKeys(val) {
+			extractIPsFromValue(val[key], ips, seen)
 		}
 	case []interface{}:
 		for _, item := range val {
 			extractIPsFromValue(item, ips, seen)
 		}
 	case string:
-		if ip := net.ParseIP(val); ip != nil {
-			ipStr := ip.String()
+		if ipStr, ok := normalizeIPWithPort(val); ok {
 			if !seen[ipStr] {
 				seen[ipStr] = true
 				*ips = append(*ips, ipStr)
@@ -217,8 +274,7 @@ func extractIPsFromXML(msg string) []string {
 	for _, match := range matches {
 		if len(match) > 1 {
 			content := strings.TrimSpace(match[1])
-			if ip := net.ParseIP(content); ip != nil {
-				ipStr := ip.String()
+			if ipStr, ok := normalizeIPWithPort(content); ok {
 				if !seen[ipStr] {
 					seen[ipStr] = true
 					ips = append(ips, ipStr)
@@ -0,0 +1,414 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"go.uber.org/zap"
+)
+
+// ProtocolHTTP marks events received by the HTTP ingestion endpoint
+const ProtocolHTTP = "http"
+
+// HTTP ingestion endpoints. The collector paths follow the Splunk HTTP Event Collector.
+const (
+	hecEventPath  = "/services/collector/event"
+	hecRawPath    = "/services/collector/raw"
+	hecHealthPath = "/services/collector/health"
+	jsonLinesPath = "/events"
+)
+
+// maxHTTPBodySize bounds a single request after decompression
+const maxHTTPBodySize = 8 * 1024 * 1024
+
+// hecResponse is the Splunk HEC status body, see its documented status codes
+type hecResponse struct {
+	Text string `json:"text"`
+	Code int    `json:"code"`
+}
+
+var (
+	hecSuccess       = hecResponse{"Success", 0}
+	hecTokenRequired = hecResponse{"Token is required", 2}
+	hecInvalidAuth   = hecResponse{"Invalid authorization", 3}
+	hecInvalidToken  = hecResponse{"Invalid token", 4}
+	hecNoData        = hecResponse{"No data", 5}
+	hecInvalidData   = hecResponse{"Invalid data format", 6}
+	hecServerBusy    = hecResponse{"Server is busy", 9}
+	hecTooLarge      = hecResponse{"Request entity too large", 6}
+	hecHealthy       = hecResponse{"HEC is healthy", 17}
+)
+
+// hecEvent is one event of the HEC event endpoint
+type hecEvent struct {
+	Event      json.RawMessage `json:"event"`
+	Time       json.RawMessage `json:"time"`
+	Host       string          `json:"host"`
+	Source     string          `json:"source"`
+	SourceType string          `json:"sourcetype"`
+}
+
+// httpIngest receives events over HTTP and hands them to the syslog pipeline
+type httpIngest struct {
+	listener *SyslogListener
+	// tokens maps accepted tokens to the name events are attributed to
+	tokens  map[string]string
+	channel chan<- receivedMessage
+	// done is closed when the server shuts down, set by serve
+	done <-chan struct{}
+
+	accepted     atomic.Uint64
+	unauthorized atomic.Uint64
+	invalid      atomic.Uint64
+}
+
+// newHTTPIngest returns nil if no HTTP ingestion address is configured.
+// Tokens are given as "name:token", a bare token is attributed to the client IP.
+func newHTTPIngest(cfg *config.Config, channel chan<- receivedMessage) (*httpIngest, error) {
+	if cfg.HTTPIngestListenAddr == "" {
+		return nil, nil
+	}
+	if len(cfg.HTTPIngestTokens) == 0 {
+		return nil, fmt.Errorf("HTTP ingestion requires at least one token")
+	}
+	if cfg.HTTPIngestParser != "" && !isKnownParser(cfg.HTTPIngestParser) {
+		return nil, fmt.Errorf("HTTP ingestion: unknown parser %q", cfg.HTTPIngestParser)
+	}
+
+	tokens := make(map[string]string, len(cfg.HTTPIngestTokens))
+	for _, entry := range cfg.HTTPIngestTokens {
+		name, token, found := strings.Cut(entry, ":")
+		if !found {
+			name, token = "", entry
+		}
+		if token == "" {
+			return nil, fmt.Errorf("HTTP ingestion: empty token for %q", name)
+		}
+		tokens[token] = name
+	}
+
+	return &httpIngest{
+		listener: &SyslogListener{
+			Name:           ProtocolHTTP,
+			Protocol:       ProtocolHTTP,
+			Address:        cfg.HTTPIngestListenAddr,
+			Parser:         cfg.HTTPIngestParser,
+			MaxMessageSize: cfg.SyslogMaxMessageSize,
+		},
+		tokens:  tokens,
+		channel: channel,
+	}, nil
+}
+
+// serve runs the HTTP server until ctx is done
+func (h *httpIngest) serve(ctx context.Context, tlsReloader *certReloader, useTLS bool) error {
+	h.done = ctx.Done()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(hecEventPath, h.handleEvents)
+	mux.HandleFunc(hecEventPath+"/1.0", h.handleEvents)
+	mux.HandleFunc(hecRawPath, h.handleLines)
+	mux.HandleFunc(hecRawPath+"/1.0", h.handleLines)
+	mux.HandleFunc(jsonLinesPath, h.handleLines)
+	mux.HandleFunc(hecHealthPath, func(w http.ResponseWriter, r *http.Request) {
+		writeHECResponse(w, http.StatusOK, hecHealthy)
+	})
+
+	server := &http.Server{
+		Addr:              h.listener.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if useTLS {
+		if tlsReloader == nil {
+			return fmt.Errorf("no TLS certificate configured")
+		}
+		server.TLSConfig = tlsReloader.tlsConfig()
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// logStats logs the request counters if any events were rejected
+func (h *httpIngest) logStats() {
+	if h == nil {
+		return
+	}
+	unauthorized, invalid := h.unauthorized.Swap(0), h.invalid.Swap(0)
+	if unauthorized == 0 && invalid == 0 {
+		return
+	}
+	zap.L().Warn("HTTP ingestion rejected requests",
+		zap.Uint64("accepted", h.accepted.Load()),
+		zap.Uint64("unauthorized", unauthorized),
+		zap.Uint64("invalid", invalid),
+	)
+}
+
+// authenticate checks the "Splunk <token>" or "Bearer <token>" authorization header
+// and returns the name of the token
+func (h *httpIngest) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		h.unauthorized.Add(1)
+		writeHECResponse(w, http.StatusUnauthorized, hecTokenRequired)
+		return "", false
+	}
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || (!strings.EqualFold(scheme, "Splunk") && !strings.EqualFold(scheme, "Bearer")) {
+		h.unauthorized.Add(1)
+		writeHECResponse(w, http.StatusUnauthorized, hecInvalidAuth)
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	for candidate, name := range h.tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	h.unauthorized.Add(1)
+	zap.L().Debug("HTTP ingestion request with invalid token",
+		zap.String("remote", r.RemoteAddr),
+	)
+	writeHECResponse(w, http.StatusForbidden, hecInvalidToken)
+	return "", false
+}
+
+// body returns the request body, decompressing gzip encoded requests. Reading more than
+// maxHTTPBodySize, before or after decompression, fails with *http.MaxBytesError.
+func (h *httpIngest) body(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	body := io.Reader(http.MaxBytesReader(w, r.Body, maxHTTPBodySize))
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		body = &maxBytesReader{r: gz, remaining: maxHTTPBodySize}
+	}
+	return body, nil
+}
+
+// maxBytesReader fails instead of truncating once more than its limit is read
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, &http.MaxBytesError{Limit: maxHTTPBodySize}
+	}
+	// Read one byte past the limit to tell a body of exactly the limit from a larger one
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n + int(m.remaining), &http.MaxBytesError{Limit: maxHTTPBodySize}
+	}
+	return n, err
+}
+
+// rejectBody answers a request whose body could not be read
+func (h *httpIngest) rejectBody(w http.ResponseWriter, err error) {
+	h.invalid.Add(1)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeHECResponse(w, http.StatusRequestEntityTooLarge, hecTooLarge)
+		return
+	}
+	writeHECResponse(w, http.StatusBadRequest, hecInvalidData)
+}
+
+// handleEvents accepts one or more concatenated HEC event objects
+func (h *httpIngest) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	body, err := h.body(w, r)
+	if err != nil {
+		h.rejectBody(w, err)
+		return
+	}
+
+	// Decode everything first so that a malformed batch is rejected as a whole
+	var batch []map[string]interface{}
+	decoder := json.NewDecoder(body)
+	for {
+		var event hecEvent
+		if err := decoder.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			h.rejectBody(w, err)
+			return
+		}
+		logParts, ok := event.logParts()
+		if !ok {
+			h.invalid.Add(1)
+			writeHECResponse(w, http.StatusBadRequest, hecResponse{"Event field is required", 12})
+			return
+		}
+		batch = append(batch, logParts)
+	}
+	if len(batch) == 0 {
+		writeHECResponse(w, http.StatusBadRequest, hecNoData)
+		return
+	}
+
+	h.submit(w, r, name, batch)
+}
+
+// handleLines accepts newline separated events, each a JSON object or plain message
+func (h *httpIngest) handleLines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	body, err := h.body(w, r)
+	if err != nil {
+		h.rejectBody(w, err)
+		return
+	}
+
+	var batch []map[string]interface{}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), h.maxMessageSize())
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		batch = append(batch, map[string]interface{}{"message": line, "raw": line})
+	}
+	if err := scanner.Err(); err != nil {
+		h.rejectBody(w, err)
+		return
+	}
+	if len(batch) == 0 {
+		writeHECResponse(w, http.StatusBadRequest, hecNoData)
+		return
+	}
+
+	h.submit(w, r, name, batch)
+}
+
+// submit passes a batch to the pipeline, attributed to the token name or else the client.
+// A batch is queued in full or not at all, so that a client retrying a failed request
+// does not duplicate events. Only a shutdown can interrupt it once the first event is queued.
+func (h *httpIngest) submit(w http.ResponseWriter, r *http.Request, name string, batch []map[string]interface{}) {
+	for i, logParts := range batch {
+		logParts["client"] = r.RemoteAddr
+		if name != "" {
+			logParts["token_name"] = name
+		}
+		cancelled := r.Context().Done()
+		if i > 0 {
+			cancelled = h.done
+		}
+		select {
+		case h.channel <- receivedMessage{logParts: logParts, listener: h.listener}:
+			h.accepted.Add(1)
+		case <-cancelled:
+			writeHECResponse(w, http.StatusServiceUnavailable, hecServerBusy)
+			return
+		}
+	}
+	writeHECResponse(w, http.StatusOK, hecSuccess)
+}
+
+func (h *httpIngest) maxMessageSize() int {
+	if h.listener.MaxMessageSize > 0 {
+		return h.listener.MaxMessageSize
+	}
+	return defaultMaxMessageSize
+}
+
+// logParts converts the event into the fields the syslog parsers read. String events
+// are the message, object events are passed to the parsers as JSON.
+func (e hecEvent) logParts() (map[string]interface{}, bool) {
+	event := bytes.TrimSpace(e.Event)
+	if len(event) == 0 || bytes.Equal(event, []byte("null")) {
+		return nil, false
+	}
+
+	var message string
+	if err := json.Unmarshal(event, &message); err != nil {
+		message = string(event)
+	}
+	logParts := map[string]interface{}{
+		"message": message,
+		"raw":     message,
+	}
+	if e.Host != "" {
+		logParts["hostname"] = e.Host
+	}
+	if e.SourceType != "" {
+		logParts["app_name"] = e.SourceType
+	} else if e.Source != "" {
+		logParts["app_name"] = e.Source
+	}
+	if ts, ok := hecTime(e.Time); ok {
+		logParts["timestamp"] = ts
+	}
+	return logParts, true
+}
+
+// hecTime parses epoch seconds, given as number or string, with optional fraction
+func hecTime(raw json.RawMessage) (time.Time, bool) {
+	value := strings.Trim(string(bytes.TrimSpace(raw)), `"`)
+	if value == "" || value == "null" {
+		return time.Time{}, false
+	}
+	var seconds float64
+	if _, err := fmt.Sscan(value, &seconds); err != nil || seconds <= 0 {
+		return time.Time{}, false
+	}
+	return epochTime(seconds), true
+}
+
+func writeHECResponse(w http.ResponseWriter, status int, response hecResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,209 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"go.uber.org/zap"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Listener protocols
+const (
+	ProtocolUDP  = "udp"
+	ProtocolTCP  = "tcp"
+	ProtocolTLS  = "tls"
+	ProtocolUnix = "unix"
+)
+
+// SyslogListener is a socket on which syslog messages are received
+type SyslogListener struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	// Path is the socket file of unix datagram listeners
+	Path string `json:"path"`
+	// Framing is auto (default), lf or octet-counting, ignored for datagram listeners
+	Framing string `json:"framing"`
+	// Parser restricts messages to a single parser unless the sender binding sets one
+	Parser string `json:"parser"`
+	// MaxMessageSize in bytes, longer messages are truncated
+	MaxMessageSize int `json:"maxMessageSize"`
+
+	stats *framingStats
+}
+
+type listenersFile struct {
+	Listeners []SyslogListener `json:"listeners"`
+}
+
+// Endpoint returns the address or socket path the listener binds
+func (l *SyslogListener) Endpoint() string {
+	if l.Protocol == ProtocolUnix {
+		return l.Path
+	}
+	return net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+}
+
+func (l *SyslogListener) validate() error {
+	switch l.Protocol {
+	case ProtocolUDP, ProtocolTCP, ProtocolTLS:
+		if l.Port <= 0 || l.Port > 65535 {
+			return fmt.Errorf("listener %q: invalid port %d", l.Name, l.Port)
+		}
+	case ProtocolUnix:
+		if l.Path == "" {
+			return fmt.Errorf("listener %q: unix listener without path", l.Name)
+		}
+	default:
+		return fmt.Errorf("listener %q: unsupported protocol %q", l.Name, l.Protocol)
+	}
+
+	switch l.Framing {
+	case "":
+		l.Framing = FramingAuto
+	case FramingAuto, FramingLF, FramingOctetCounting:
+	default:
+		return fmt.Errorf("listener %q: unknown framing %q", l.Name, l.Framing)
+	}
+
+	if l.Parser != "" && !isKnownParser(l.Parser) {
+		return fmt.Errorf("listener %q: unknown parser %q", l.Name, l.Parser)
+	}
+	if l.MaxMessageSize < 0 {
+		return fmt.Errorf("listener %q: invalid maximum message size %d", l.Name, l.MaxMessageSize)
+	}
+	return nil
+}
+
+// LoadListeners returns the listeners from the listeners file, or else the
+// UDP/TCP pair, TLS listener and unix socket given by the individual settings
+func LoadListeners(cfg *config.Config) ([]SyslogListener, error) {
+	var listeners []SyslogListener
+	if cfg.SyslogListenersFile != "" {
+		data, err := os.ReadFile(cfg.SyslogListenersFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read syslog listeners file: %w", err)
+		}
+		var file listenersFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to decode syslog listeners file: %w", err)
+		}
+		listeners = file.Listeners
+	} else {
+		listeners = []SyslogListener{
+			{Name: "udp", Protocol: ProtocolUDP, Address: cfg.SyslogListenAddr, Port: cfg.SyslogPort},
+			{Name: "tcp", Protocol: ProtocolTCP, Address: cfg.SyslogListenAddr, Port: cfg.SyslogPort},
+		}
+		if cfg.SyslogTLSPort > 0 {
+			listeners = append(listeners, SyslogListener{Name: "tls", Protocol: ProtocolTLS, Address: cfg.SyslogListenAddr, Port: cfg.SyslogTLSPort})
+		}
+		if cfg.SyslogUnixSocket != "" {
+			listeners = append(listeners, SyslogListener{Name: "unix", Protocol: ProtocolUnix, Path: cfg.SyslogUnixSocket})
+		}
+	}
+
+	for i := range listeners {
+		l := &listeners[i]
+		if l.Name == "" {
+			l.Name = l.Protocol + "-" + strconv.Itoa(i)
+		}
+		if l.MaxMessageSize == 0 {
+			l.MaxMessageSize = cfg.SyslogMaxMessageSize
+		}
+		if err := l.validate(); err != nil {
+			return nil, err
+		}
+		l.stats = newFramingStats(l.Name)
+	}
+	return listeners, nil
+}
+
+// format returns the go-syslog format for the listener's framing
+func (l *SyslogListener) format() format.Format {
+	maxSize := l.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxMessageSize
+	}
+
+	f := &rawFormat{Format: syslog.Automatic}
+	if l.Protocol == ProtocolTCP || l.Protocol == ProtocolTLS {
+		f.newSplit = func() bufio.SplitFunc {
+			return newFrameSplitter(l.Framing, maxSize, l.stats).split
+		}
+	} else {
+		f.newSplit = func() bufio.SplitFunc {
+			return datagramSplit(maxSize, l.stats)
+		}
+	}
+	return f
+}
+
+// listen binds the listener on server
+func (l *SyslogListener) listen(server *syslog.Server, tlsReloader *certReloader) error {
+	switch l.Protocol {
+	case ProtocolUDP:
+		return server.ListenUDP(l.Endpoint())
+	case ProtocolTCP:
+		return server.ListenTCP(l.Endpoint())
+	case ProtocolTLS:
+		if tlsReloader == nil {
+			return fmt.Errorf("no TLS certificate configured")
+		}
+		if err := server.ListenTCPTLS(l.Endpoint(), tlsReloader.tlsConfig()); err != nil {
+			return err
+		}
+		server.SetTlsPeerNameFunc(tlsPeerName)
+		return nil
+	case ProtocolUnix:
+		// Remove a socket left behind by a previous run
+		if info, err := os.Lstat(l.Path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(l.Path); err != nil {
+				return fmt.Errorf("failed to remove stale socket: %w", err)
+			}
+		}
+		return server.ListenUnixgram(l.Path)
+	}
+	return fmt.Errorf("unsupported protocol %q", l.Protocol)
+}
+
+// close releases resources held after the server stopped
+func (l *SyslogListener) close() {
+	if l.Protocol == ProtocolUnix {
+		if err := os.Remove(l.Path); err != nil && !os.IsNotExist(err) {
+			zap.L().Warn("Failed to remove syslog unix socket",
+				zap.String("path", l.Path),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// receivedMessage is a parsed message together with the listener it arrived on
+type receivedMessage struct {
+	logParts format.LogParts
+	listener *SyslogListener
+}
+
+// listenerHandler tags messages with their listener before passing them on
+type listenerHandler struct {
+	listener *SyslogListener
+	channel  chan<- receivedMessage
+}
+
+func (h *listenerHandler) Handle(logParts format.LogParts, messageLength int64, err error) {
+	// Fill an empty hostname from the client address, go-syslog skips this for rawFormat
+	if hostname, _ := logParts["hostname"].(string); hostname == "" {
+		if sender := senderFromLogParts(logParts); sender != "" {
+			logParts["hostname"] = sender
+		}
+	}
+	h.channel <- receivedMessage{logParts: logParts, listener: h.listener}
+}
@@ -0,0 +1,159 @@
+package syslog
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+	"go.uber.org/zap"
+)
+
+// maxTrackedSkewSenders bounds the per-sender clock skew state
+const maxTrackedSkewSenders = 1000
+
+// severityNames are the RFC 5424 severity keywords by severity code
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// applyEventMetadata copies hostname, program, severity and timestamp from the syslog header.
+// RFC 3164 timestamps without a zone are read in zone, see rfc3164Timestamp.
+func applyEventMetadata(logParts map[string]interface{}, source *types.Source, zone *time.Location) {
+	if hostname, ok := logParts["hostname"].(string); ok && hostname != "-" {
+		source.Hostname = hostname
+	}
+	source.Program = programFromLogParts(logParts)
+	if severity, ok := logParts["severity"].(int); ok && severity >= 0 && severity < len(severityNames) {
+		source.Severity = severityNames[severity]
+	}
+	ts, ok := logParts["timestamp"].(time.Time)
+	if !ok || ts.IsZero() {
+		return
+	}
+	if _, rfc3164 := logParts["tag"]; rfc3164 {
+		raw, _ := logParts["raw"].(string)
+		if ts, ok = rfc3164Timestamp(raw, zone, time.Now()); !ok {
+			return
+		}
+	}
+	source.EventTime = &ts
+}
+
+// rfc3164Timestamp reads the timestamp from the raw message, as go-syslog reads zoneless
+// "Jan _2 15:04:05" timestamps as UTC and substitutes the receive time if there is none.
+// Zoneless timestamps are read in zone, one more than a day ahead of now is taken to be
+// from the previous year.
+func rfc3164Timestamp(raw string, zone *time.Location, now time.Time) (time.Time, bool) {
+	header, ok := strings.CutPrefix(strings.TrimSpace(raw), "<")
+	if !ok {
+		return time.Time{}, false
+	}
+	if _, header, ok = strings.Cut(header, ">"); !ok {
+		return time.Time{}, false
+	}
+
+	if field, _, _ := strings.Cut(header, " "); field != "" {
+		if ts, err := time.Parse(time.RFC3339, field); err == nil {
+			return ts, true
+		}
+	}
+	if len(header) < len(time.Stamp) {
+		return time.Time{}, false
+	}
+	if zone == nil {
+		zone = time.Local
+	}
+	stamp, err := time.Parse(time.Stamp, header[:len(time.Stamp)])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	ts := time.Date(now.In(zone).Year(), stamp.Month(), stamp.Day(),
+		stamp.Hour(), stamp.Minute(), stamp.Second(), 0, zone)
+	if ts.Sub(now) > 24*time.Hour {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts, true
+}
+
+type senderSkew struct {
+	latest   time.Duration
+	max      time.Duration
+	skewed   uint64
+	reported bool
+}
+
+// clockSkewTracker detects senders whose clock differs from the sensor's by more than threshold
+type clockSkewTracker struct {
+	threshold time.Duration
+
+	mu      sync.Mutex
+	senders map[string]*senderSkew
+}
+
+func newClockSkewTracker(threshold time.Duration) *clockSkewTracker {
+	return &clockSkewTracker{threshold: threshold, senders: make(map[string]*senderSkew)}
+}
+
+// observe records the skew of a message, positive if the sender's clock is behind
+func (t *clockSkewTracker) observe(sender string, eventTime *time.Time, receivedAt time.Time) {
+	if t.threshold <= 0 || eventTime == nil {
+		return
+	}
+	skew := receivedAt.Sub(*eventTime)
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+
+	t.mu.Lock()
+	state, ok := t.senders[sender]
+	if !ok {
+		if abs <= t.threshold || len(t.senders) >= maxTrackedSkewSenders {
+			t.mu.Unlock()
+			return
+		}
+		state = &senderSkew{}
+		t.senders[sender] = state
+	}
+	state.latest = skew
+	if abs > t.threshold {
+		state.skewed++
+		if abs > state.max {
+			state.max = abs
+		}
+	}
+	firstReport := abs > t.threshold && !state.reported
+	state.reported = state.reported || firstReport
+	t.mu.Unlock()
+
+	if firstReport {
+		zap.L().Warn("Syslog sender clock skew exceeds threshold",
+			zap.String("sender", sender),
+			zap.Duration("skew", skew),
+			zap.Duration("threshold", t.threshold),
+			zap.Time("eventTime", *eventTime),
+		)
+	}
+}
+
+// log reports senders with skewed messages since the last call and resets their counters.
+// Senders which are back in sync are forgotten.
+func (t *clockSkewTracker) log() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for sender, state := range t.senders {
+		if state.skewed == 0 {
+			delete(t.senders, sender)
+			continue
+		}
+		zap.L().Warn("Syslog sender clock skew",
+			zap.String("sender", sender),
+			zap.Duration("latestSkew", state.latest),
+			zap.Duration("maxSkew", state.max),
+			zap.Uint64("skewedMessages", state.skewed),
+		)
+		state.skewed = 0
+		state.max = 0
+	}
+}
@@ -1,25 +1,175 @@
 package syslog
 
-import "go.uber.org/zap"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
 
-func inferSrcDst(logParts map[string]interface{}) (src, dst, msg string) {
-	var msgField string
+	"go.uber.org/zap"
+)
 
+// extractMessage returns the message text and the logParts field it was found in
+func extractMessage(logParts map[string]interface{}) (msg, msgField string) {
 	// Determine which field contains the message
-	if m, ok := logParts["content"].(string); ok {
-		msg = m
-		msgField = "content"
-	} else if m, ok := logParts["message"].(string); ok {
-		msg = m
-		msgField = "message"
-	} else if m, ok := logParts["msg"].(string); ok {
-		msg = m
-		msgField = "msg"
-	} else {
-		zap.L().Warn("No message found in logParts",
+	for _, field := range []string{"content", "message", "msg"} {
+		if m, ok := logParts[field].(string); ok {
+			return m, field
+		}
+	}
+	return "", ""
+}
+
+// parserInput returns the text the named parser reads. The json and fields parsers also
+// read the additional fields of GELF messages in logParts["fields"], as a JSON object
+// including the message or as key=value pairs following it.
+func parserInput(parser, msg string, logParts map[string]interface{}) string {
+	fields, ok := logParts["fields"].(map[string]interface{})
+	if !ok || len(fields) == 0 {
+		return msg
+	}
+	switch parser {
+	case ParserJSON:
+		object := make(map[string]interface{}, len(fields)+1)
+		for key, value := range fields {
+			object[key] = value
+		}
+		object["message"] = msg
+		encoded, err := json.Marshal(object)
+		if err != nil {
+			return msg
+		}
+		return string(encoded)
+	case ParserFields:
+		var b strings.Builder
+		b.WriteString(msg)
+		for _, key := range sortedKeys(fields) {
+			fmt.Fprintf(&b, " %s=%v", key, fields[key])
+		}
+		return b.String()
+	}
+	return msg
+}
+
+// inferSecurityEvent extracts a single offending IP and its event category
+// from auth and application logs (sshd, Postfix, Dovecot, OpenVPN, web servers)
+func inferSecurityEvent(logParts map[string]interface{}) (ip, category, msg string) {
+	msg, msgField := extractMessage(logParts)
+	if msgField == "" {
+		return "", "", ""
+	}
+
+	candidate, category, parser := extractSecurityEvent(msg)
+	if candidate == "" {
+		return "", "", msg
+	}
+
+	normalized, ok := normalizeIP(candidate)
+	if !ok || isReservedOrInvalidIP(normalized) {
+		zap.L().Debug("Security event IP found but filtered as invalid",
+			zap.String("parser", parser),
+			zap.String("ip", candidate),
+		)
+		return "", "", msg
+	}
+
+	return normalized, category, msg
+}
+
+// srcDstParser extracts candidate IPs from a message, ordered so that
+// the first valid pair is the most likely source and destination
+type srcDstParser struct {
+	Name string
+	// Extract is passed the custom field mappings of structured logs
+	Extract func(msg string, custom []fieldMapping) []string
+	// Action returns the normalised firewall action of the message, if any
+	Action func(msg string) string
+	// Warn marks last-resort parsers whose matches should be reviewed
+	Warn bool
+}
+
+// Parser names which senders can be bound to
+const (
+	ParserCEF      = "cef"
+	ParserCiscoASA = "cisco-asa"
+	ParserCiscoIOS = "cisco-ios"
+	ParserPf       = "pf"
+	ParserJSON     = "json"
+	ParserXML      = "xml"
+	ParserGeneric  = "generic"
+	ParserFields   = "fields"
+	ParserSecurity = "security"
+)
+
+// srcDstParsers are tried in order until one yields a valid (or filtered) pair
+var srcDstParsers = []srcDstParser{
+	{Name: ParserCEF, Extract: pairOf(extractCEFSrcDst), Action: cefAction},
+	{Name: ParserCiscoASA, Extract: func(msg string, _ []fieldMapping) []string {
+		if event, ok := extractCiscoAsa(msg); ok {
+			return []string{event.Src, event.Dst}
+		}
+		return nil
+	}, Action: ciscoAsaAction},
+	{Name: ParserCiscoIOS, Extract: pairOf(extractCiscoIosSrcDst), Action: ciscoIosAction},
+	{Name: ParserPf, Extract: pairOf(extractPfSrcDst), Action: pfAction},
+	{Name: ParserJSON, Extract: func(msg string, custom []fieldMapping) []string {
+		if detectStructuredFormat(msg) != "json" {
+			return nil
+		}
+		return extractJSONSrcDst(msg, custom)
+	}, Action: jsonAction},
+	{Name: ParserXML, Extract: func(msg string, custom []fieldMapping) []string {
+		if detectStructuredFormat(msg) != "xml" {
+			return nil
+		}
+		return extractXMLSrcDst(msg, custom)
+	}, Action: xmlAction},
+	// Fallback 1: extract all IPs and use first two uniqe ones as src/dst
+	{Name: ParserGeneric, Extract: unstructured(extractIPs), Action: keyValueAction},
+	// Fallback 2: try parsing every field as an IP
+	{Name: ParserFields, Extract: unstructured(extractIPsFromAllFields), Action: keyValueAction, Warn: true},
+}
+
+// unstructured adapts extractors which do not use field mappings
+func unstructured(extract func(msg string) []string) func(string, []fieldMapping) []string {
+	return func(msg string, _ []fieldMapping) []string {
+		return extract(msg)
+	}
+}
+
+func pairOf(extract func(msg string) (src, dst string)) func(string, []fieldMapping) []string {
+	return func(msg string, _ []fieldMapping) []string {
+		src, dst := extract(msg)
+		if src == "" || dst == "" {
+			return nil
+		}
+		return []string{src, dst}
+	}
+}
+
+// isKnownParser reports whether name can be used to bind a sender to a parser
+func isKnownParser(name string) bool {
+	if name == ParserSecurity {
+		return true
+	}
+	for _, p := range srcDstParsers {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inferSrcDst extracts source and destination from a syslog message.
+// If parser is set only that parser is tried, otherwise all parsers are tried in order.
+// custom are the field mappings tried first for structured logs.
+// used is the name of the parser which produced the pair, failure the Failure* reason if none did.
+func inferSrcDst(logParts map[string]interface{}, parser string, custom []fieldMapping) (src, dst, used, failure string) {
+	msg, msgField := extractMessage(logParts)
+	if msgField == "" {
+		zap.L().Debug("No message found in logParts",
 			zap.Any("logPartsKeys", logPartsKeys(logParts)),
 		)
-		return "", "", ""
+		return "", "", "", FailureNoMessage
 	}
 
 	zap.L().Debug("Extracted message from logParts",
@@ -27,171 +177,68 @@ func inferSrcDst(logParts map[string]interface{}) (src, dst, msg string) {
 		zap.String("message", msg),
 	)
 
-	// Try to extract src/dst from CEF-style logs
-	if srcCEF, dstCEF := extractCEFSrcDst(msg); srcCEF != "" && dstCEF != "" {
-		if validSrc, validDst, srcInvalid, dstInvalid := validateSrcDst(srcCEF, dstCEF); validSrc != "" && validDst != "" {
-			zap.L().Debug("Extracted source and destination from CEF",
-				zap.String("src", validSrc),
-				zap.String("dst", validDst),
-			)
-			return validSrc, validDst, msg
-		} else if srcInvalid || dstInvalid {
-			zap.L().Debug("CEF IPs found but filtered as invalid",
-				zap.String("src", srcCEF),
-				zap.String("dst", dstCEF),
-				zap.Bool("srcInvalid", srcInvalid),
-				zap.Bool("dstInvalid", dstInvalid),
-			)
-			return "", "", msg
+	for _, p := range srcDstParsers {
+		if parser != "" && p.Name != parser {
+			continue
 		}
-	}
 
-	// Try to extract src/dst from Cisco-style logs
-	if srcCisco, dstCisco := extractCiscoIosSrcDst(msg); srcCisco != "" && dstCisco != "" {
-		if validSrc, validDst, srcInvalid, dstInvalid := validateSrcDst(srcCisco, dstCisco); validSrc != "" && validDst != "" {
-			zap.L().Debug("Extracted source and destination from Cisco",
-				zap.String("src", validSrc),
-				zap.String("dst", validDst),
-			)
-			return validSrc, validDst, msg
-		} else if srcInvalid || dstInvalid {
-			zap.L().Debug("Cisco IPs found but filtered as invalid",
-				zap.String("src", srcCisco),
-				zap.String("dst", dstCisco),
-				zap.Bool("srcInvalid", srcInvalid),
-				zap.Bool("dstInvalid", dstInvalid),
-			)
-			return "", "", msg
+		ips := p.Extract(parserInput(p.Name, msg, logParts), custom)
+		if len(ips) < 2 {
+			continue
+		}
+
+		validSrc, validDst, filtered := pickSrcDst(ips)
+		if filtered {
+			return "", "", p.Name, FailureFilteredReserved
+		}
+		if validSrc == "" {
+			continue
 		}
-	}
 
-	// Try to extract src/dst from pf filterlog format
-	if srcPf, dstPf := extractPfSrcDst(msg); srcPf != "" && dstPf != "" {
-		if validSrc, validDst, srcInvalid, dstInvalid := validateSrcDst(srcPf, dstPf); validSrc != "" && validDst != "" {
-			zap.L().Debug("Extracted source and destination from pf log",
+		if p.Warn {
+			zap.L().Warn("Extracted source and destination using field-by-field parsing (no structured format matched)",
+				zap.Strings("ips", ips),
 				zap.String("src", validSrc),
 				zap.String("dst", validDst),
+				zap.String("message", msg),
 			)
-			return validSrc, validDst, msg
-		} else if srcInvalid || dstInvalid {
-			zap.L().Debug("pf IPs found but filtered as invalid",
-				zap.String("src", srcPf),
-				zap.String("dst", dstPf),
-				zap.Bool("srcInvalid", srcInvalid),
-				zap.Bool("dstInvalid", dstInvalid),
+		} else {
+			zap.L().Debug("Extracted source and destination",
+				zap.String("parser", p.Name),
+				zap.Strings("ips", ips),
+				zap.String("src", validSrc),
+				zap.String("dst", validDst),
 			)
-			return "", "", msg
 		}
+		return validSrc, validDst, p.Name, ""
 	}
 
-	// Detect and handle structured formats
-	format := detectStructuredFormat(msg)
-	var ipsStructured []string
-
-	switch format {
-	case "json":
-		ipsStructured = extractIPsFromJSON(msg)
-		if len(ipsStructured) >= 2 {
-			for i := 0; i < len(ipsStructured)-1; i++ {
-				for j := i + 1; j < len(ipsStructured); j++ {
-					if validSrc, validDst, srcInvalid, dstInvalid := validateSrcDst(ipsStructured[i], ipsStructured[j]); validSrc != "" && validDst != "" {
-						zap.L().Debug("Extracted source and destination from JSON",
-							zap.Strings("ips", ipsStructured),
-							zap.String("src", validSrc),
-							zap.String("dst", validDst),
-						)
-						return validSrc, validDst, msg
-					} else if srcInvalid || dstInvalid {
-						zap.L().Debug("JSON IPs found but filtered as invalid",
-							zap.String("src", ipsStructured[i]),
-							zap.String("dst", ipsStructured[j]),
-							zap.Bool("srcInvalid", srcInvalid),
-							zap.Bool("dstInvalid", dstInvalid),
-						)
-						return "", "", msg
-					}
-				}
-			}
-		}
-	case "xml":
-		ipsStructured = extractIPsFromXML(msg)
-		if len(ipsStructured) >= 2 {
-			for i := 0; i < len(ipsStructured)-1; i++ {
-				for j := i + 1; j < len(ipsStructured); j++ {
-					if validSrc, validDst, srcInvalid, dstInvalid := validateSrcDst(ipsStructured[i], ipsStructured[j]); validSrc != "" && validDst != "" {
-						zap.L().Debug("Extracted source and destination from XML",
-							zap.Strings("ips", ipsStructured),
-							zap.String("src", validSrc),
-							zap.String("dst", validDst),
-						)
-						return validSrc, validDst, msg
-					} else if srcInvalid || dstInvalid {
-						zap.L().Debug("XML IPs found but filtered as invalid",
-							zap.String("src", ipsStructured[i]),
-							zap.String("dst", ipsStructured[j]),
-							zap.Bool("srcInvalid", srcInvalid),
-							zap.Bool("dstInvalid", dstInvalid),
-						)
-						return "", "", msg
-					}
-				}
-			}
-		}
-	}
+	zap.L().Debug("No source or destination found in message",
+		zap.String("parser", parser),
+		zap.String("message", msg),
+	)
+	return "", "", "", FailureFewerThanTwoIPs
+}
 
-	// Fallback 1: extract all IPs and use first two uniqe ones as src/dst
-	ips := extractIPs(msg)
-	if len(ips) >= 2 {
-		for i := 0; i < len(ips)-1; i++ {
-			for j := i + 1; j < len(ips); j++ {
-				if validSrc, validDst, srcInvalid, dstInvalid := validateSrcDst(ips[i], ips[j]); validSrc != "" && validDst != "" {
-					zap.L().Debug("Extracted source and destination from IPs",
-						zap.Strings("ips", ips),
-						zap.String("src", validSrc),
-						zap.String("dst", validDst),
-					)
-					return validSrc, validDst, msg
-				} else if srcInvalid || dstInvalid {
-					zap.L().Debug("IPs found but filtered as invalid",
-						zap.String("src", ips[i]),
-						zap.String("dst", ips[j]),
-						zap.Bool("srcInvalid", srcInvalid),
-						zap.Bool("dstInvalid", dstInvalid),
-					)
-					return "", "", msg
-				}
+// pickSrcDst returns the first valid pair of distinct IPs. If a pair is found where
+// either IP is reserved or invalid, filtered is true and the message should be skipped.
+func pickSrcDst(ips []string) (src, dst string, filtered bool) {
+	for i := 0; i < len(ips)-1; i++ {
+		for j := i + 1; j < len(ips); j++ {
+			validSrc, validDst, srcInvalid, dstInvalid := validateSrcDst(ips[i], ips[j])
+			if validSrc != "" && validDst != "" {
+				return validSrc, validDst, false
 			}
-		}
-	}
-
-	// Fallback 2: try parsing every field as an IP
-	ipsFromFields := extractIPsFromAllFields(msg)
-	if len(ipsFromFields) >= 2 {
-		for i := 0; i < len(ipsFromFields)-1; i++ {
-			for j := i + 1; j < len(ipsFromFields); j++ {
-				if validSrc, validDst, srcInvalid, dstInvalid := validateSrcDst(ipsFromFields[i], ipsFromFields[j]); validSrc != "" && validDst != "" {
-					zap.L().Warn("Extracted source and destination using field-by-field parsing (no structured format matched)",
-						zap.Strings("ips", ipsFromFields),
-						zap.String("src", validSrc),
-						zap.String("dst", validDst),
-						zap.String("message", msg),
-					)
-					return validSrc, validDst, msg
-				} else if srcInvalid || dstInvalid {
-					zap.L().Debug("Field-parsed IPs found but filtered as invalid",
-						zap.String("src", ipsFromFields[i]),
-						zap.String("dst", ipsFromFields[j]),
-						zap.Bool("srcInvalid", srcInvalid),
-						zap.Bool("dstInvalid", dstInvalid),
-					)
-					return "", "", msg
-				}
+			if srcInvalid || dstInvalid {
+				zap.L().Debug("IPs found but filtered as invalid",
+					zap.String("src", ips[i]),
+					zap.String("dst", ips[j]),
+					zap.Bool("srcInvalid", srcInvalid),
+					zap.Bool("dstInvalid", dstInvalid),
+				)
+				return "", "", true
 			}
 		}
 	}
-
-	zap.L().Warn("No source or destination found in message",
-		zap.String("message", msg),
-	)
-	return "", "", msg
+	return "", "", false
 }
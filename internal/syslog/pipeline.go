@@ -0,0 +1,246 @@
+package syslog
+
+import (
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/recommender"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/whitelist"
+	"go.uber.org/zap"
+)
+
+// pipeline holds everything needed to turn a received message into evaluations
+type pipeline struct {
+	cfg              *config.Config
+	whitelistManager *whitelist.WhitelistManager
+	evaluationFunc   types.EvaluationFunc
+	senders          *SenderRegistry
+	rules            *RuleStore
+	forwarder        *Forwarder
+	clockSkew        *clockSkewTracker
+	// fieldMappings are SYSLOG_JSON_FIELD_MAPPINGS, tried before the built-in ones
+	fieldMappings []fieldMapping
+}
+
+func newPipeline(cfg *config.Config, whitelistManager *whitelist.WhitelistManager, evaluationFunc types.EvaluationFunc) (*pipeline, error) {
+	senders, err := NewSenderRegistry(cfg.SyslogAllowedSenders, cfg.SyslogSendersFile)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := NewRuleStore(cfg.SyslogRulesFile)
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := parseFieldMappings(cfg.SyslogJSONFieldMappings)
+	if err != nil {
+		return nil, err
+	}
+	forwarder, err := NewForwarder(cfg.SyslogForwardFile, shouldBlockVerdict)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipeline{
+		cfg:              cfg,
+		whitelistManager: whitelistManager,
+		evaluationFunc:   evaluationFunc,
+		senders:          senders,
+		rules:            rules,
+		forwarder:        forwarder,
+		clockSkew:        newClockSkewTracker(cfg.SyslogClockSkewThreshold),
+		fieldMappings:    mappings,
+	}, nil
+}
+
+// parsedMessage is what the parsers found in a received message
+type parsedMessage struct {
+	Source types.Source
+	Sender string
+	// Parser is the parser or "rule:<name>" which produced the IPs, empty if none did
+	Parser string
+	Src    string
+	// Dst is empty for single-IP security events
+	Dst string
+	// Failure is the reason no IPs were extracted
+	Failure string
+}
+
+// process runs a received message through the sender checks, parsers,
+// forwarding, whitelist and finally the evaluation function
+func (p *pipeline) process(logParts map[string]interface{}, listener *SyslogListener) {
+	sender := ""
+	switch listener.Protocol {
+	case ProtocolUnix:
+		// Local unix socket senders are trusted, file permissions guard the socket
+	case ProtocolHTTP:
+		// HTTP senders are authenticated by their token
+		sender = senderFromLogParts(logParts)
+	default:
+		sender = senderFromLogParts(logParts)
+		if !p.senders.IsAllowed(sender) {
+			return
+		}
+	}
+
+	parsed := p.parse(logParts, sender, listener.Parser)
+	switch listener.Protocol {
+	case ProtocolUnix:
+		parsed.Source.SourceName = "local"
+	case ProtocolHTTP, ProtocolGELF:
+		parsed.Source.SourceType = listener.Protocol
+	}
+	p.clockSkew.observe(parsed.Source.SourceName, parsed.Source.EventTime, time.Now())
+	if parsed.Failure != "" {
+		message, _ := logParts["raw"].(string)
+		if message == "" {
+			message, _ = extractMessage(logParts)
+		}
+		recordParseFailure(parsed.Source.SourceName, parsed.Failure, parsed.Parser, message)
+	}
+	p.forwarder.Forward(logParts, parsed)
+	p.evaluate(parsed)
+}
+
+// parse identifies the source of a message and extracts the IPs to evaluate.
+// parser is the listener's parser profile, a sender binding's parser takes precedence.
+func (p *pipeline) parse(logParts map[string]interface{}, sender, parser string) parsedMessage {
+	source := types.Source{SourceType: "syslog", SourceName: sender}
+	if source.SourceName == "" {
+		source.SourceName = "unknown"
+	}
+	// Authenticated TLS senders are identified by their client certificate CN
+	if peer, ok := logParts["tls_peer"].(string); ok && peer != "" {
+		source.SourceName = peer
+	}
+	// HTTP events are identified by the name of their token
+	if name, ok := logParts["token_name"].(string); ok && name != "" {
+		source.SourceName = name
+	}
+
+	applyEventMetadata(logParts, &source, p.cfg.SyslogTimezone)
+
+	// Bound senders use their friendly name and may be restricted to a single parser
+	binding, bound := p.senders.Lookup(sender)
+	if bound {
+		if binding.Name != "" {
+			source.SourceName = binding.Name
+		}
+		source.Site = binding.Site
+		if binding.Parser != "" {
+			parser = binding.Parser
+		}
+	}
+
+	parsed := parsedMessage{Source: source, Sender: sender}
+
+	// User-defined extraction rules take precedence over the built-in parsers
+	if msg, msgField := extractMessage(logParts); msgField != "" {
+		if match, ok := p.rules.Match(programFromLogParts(logParts), sender, msg); ok {
+			src, dst, srcInvalid, dstInvalid := validateSrcDst(match.Captures[CaptureSrc], match.Captures[CaptureDst])
+			zap.L().Debug("Extracted source and destination using extraction rule",
+				zap.String("rule", match.Rule),
+				zap.Any("captures", match.Captures),
+				zap.String("src", src),
+				zap.String("dst", dst),
+			)
+			parsed.Parser = "rule:" + match.Rule
+			parsed.Src, parsed.Dst = src, dst
+			parsed.Source.Action = normalizeAction(match.Captures[CaptureAction])
+			parsed.Source.SrcPort = parsePort(match.Captures[CaptureSport])
+			parsed.Source.DstPort = parsePort(match.Captures[CaptureDport])
+			if srcInvalid || dstInvalid {
+				parsed.Failure = FailureFilteredReserved
+			} else if src == "" || dst == "" {
+				parsed.Failure = FailureFewerThanTwoIPs
+			}
+			return parsed
+		}
+	}
+
+	// Single-IP events (auth failures, WAF blocks, ...) are evaluated on their own
+	if p.cfg.SyslogSingleIPEvents && (parser == "" || parser == ParserSecurity) {
+		if ip, category, _ := inferSecurityEvent(logParts); ip != "" {
+			parsed.Source.Category = category
+			parsed.Parser = ParserSecurity
+			parsed.Src = ip
+			return parsed
+		}
+	}
+	if parser == ParserSecurity {
+		zap.L().Debug("Skipping message: no security event found for security parser",
+			zap.String("sender", sender),
+		)
+		parsed.Parser = ParserSecurity
+		parsed.Failure = FailureNoSecurityEvent
+		return parsed
+	}
+
+	parsed.Src, parsed.Dst, parsed.Parser, parsed.Failure = inferSrcDst(logParts, parser, p.fieldMappings)
+	if parsed.Failure == "" {
+		msg, _ := extractMessage(logParts)
+		parsed.Source.Action = extractAction(parsed.Parser, parserInput(parsed.Parser, msg, logParts))
+		parsed.Source.SrcPort, parsed.Source.DstPort = extractPorts(msg, parsed.Src, parsed.Dst)
+	}
+	if parsed.Parser == "" {
+		parsed.Parser = parser
+	}
+	return parsed
+}
+
+// evaluation is a single call of the evaluation function
+type evaluation struct {
+	Direction string
+	IP        string
+	RelatedIP string
+}
+
+// evaluate hands the extracted IPs to the evaluation function
+func (p *pipeline) evaluate(parsed parsedMessage) {
+	for _, e := range p.evaluations(parsed) {
+		go p.evaluationFunc(p.cfg, e.Direction, e.IP, e.RelatedIP, parsed.Source)
+	}
+}
+
+// evaluations returns the evaluation calls for a parsed message,
+// none if no IPs were extracted or they are whitelisted
+func (p *pipeline) evaluations(parsed parsedMessage) []evaluation {
+	if parsed.Failure != "" {
+		return nil
+	}
+
+	// Single-IP security events are evaluated on their own
+	if parsed.Parser == ParserSecurity {
+		if !recommender.ShouldProcessIP(p.whitelistManager, parsed.Src) {
+			zap.L().Debug("Skipping security event: filtered by whitelist",
+				zap.String("ip", parsed.Src),
+				zap.String("category", parsed.Source.Category),
+			)
+			return nil
+		}
+		return []evaluation{{Direction: "source", IP: parsed.Src}}
+	}
+
+	// Early exit if no valid IPs were extracted
+	// Empty strings mean either no IPs found or IPs were filtered as invalid
+	if parsed.Src == "" || parsed.Dst == "" {
+		zap.L().Debug("Skipping message: no valid source/destination",
+			zap.String("src", parsed.Src),
+			zap.String("dst", parsed.Dst),
+		)
+		return nil
+	}
+
+	if !recommender.ShouldProcessPacket(p.whitelistManager, parsed.Src, parsed.Dst) {
+		zap.L().Debug("Skipping message: filtered by whitelist",
+			zap.String("src", parsed.Src),
+			zap.String("dst", parsed.Dst),
+		)
+		return nil
+	}
+
+	return []evaluation{
+		{Direction: "source", IP: parsed.Src, RelatedIP: parsed.Dst},
+		{Direction: "destination", IP: parsed.Dst, RelatedIP: parsed.Src},
+	}
+}
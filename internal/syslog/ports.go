@@ -0,0 +1,78 @@
+package syslog
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	kvSrcPortRe = regexp.MustCompile(`(?i)\b(?:spt|sport|srcport|src_port|s_port|source_port|source\.port)"?\s*[=:]\s*"?(\d{1,5})\b`)
+	kvDstPortRe = regexp.MustCompile(`(?i)\b(?:dpt|dport|dstport|dst_port|d_port|destination_port|destination\.port)"?\s*[=:]\s*"?(\d{1,5})\b`)
+)
+
+// portAfter finds the port written right after an IP, as in 10.0.0.1/443 (ASA),
+// 10.0.0.1(443) (IOS), 10.0.0.1:443 or 10.0.0.1.443 (tcpdump style)
+func portAfter(msg, ip string) uint16 {
+	if ip == "" {
+		return 0
+	}
+	ipv6 := strings.Contains(ip, ":")
+	for offset := 0; ; {
+		i := strings.Index(msg[offset:], ip)
+		if i < 0 {
+			return 0
+		}
+		start, end := offset+i, offset+i+len(ip)
+		offset = end
+		if end+1 >= len(msg) {
+			return 0
+		}
+		// Part of a longer address, e.g. 10.0.0.1 within 110.0.0.1
+		if start > 0 && (isDigit(msg[start-1]) || msg[start-1] == '.') {
+			continue
+		}
+		switch msg[end] {
+		case ':', '.':
+			// Ambiguous with the address itself for IPv6
+			if ipv6 {
+				continue
+			}
+		case '/', '(':
+		default:
+			// No port, or part of a longer address, e.g. 10.0.0.1 within 10.0.0.12
+			continue
+		}
+		digits := end + 1
+		for digits < len(msg) && digits-end <= 5 && isDigit(msg[digits]) {
+			digits++
+		}
+		// Too long for a port
+		if digits < len(msg) && isDigit(msg[digits]) {
+			continue
+		}
+		if port := parsePort(msg[end+1 : digits]); port != 0 {
+			return port
+		}
+	}
+}
+
+// extractPorts returns the source and destination ports of a message, 0 where none is found.
+// Ports next to the extracted IPs take precedence over key-value fields.
+func extractPorts(msg, src, dst string) (uint16, uint16) {
+	srcPort, dstPort := portAfter(msg, src), portAfter(msg, dst)
+	if srcPort == 0 {
+		if m := kvSrcPortRe.FindStringSubmatch(msg); m != nil {
+			srcPort = parsePort(m[1])
+		}
+	}
+	if dstPort == 0 {
+		if m := kvDstPortRe.FindStringSubmatch(msg); m != nil {
+			dstPort = parsePort(m[1])
+		}
+	}
+	return srcPort, dstPort
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
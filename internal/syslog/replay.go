@@ -0,0 +1,90 @@
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/whitelist"
+)
+
+// ReplayDecider evaluates one IP of a replayed message and describes the decision
+type ReplayDecider func(direction, ip, relatedIP string, source types.Source) string
+
+// ReplayOptions set how replayed lines are attributed
+type ReplayOptions struct {
+	// Sender is the IP the lines are treated as received from
+	Sender string
+	// Parser restricts the lines to one parser, like a listener's parser profile
+	Parser string
+}
+
+// Replay runs every line of samples through the same parsing and whitelist path as
+// StartSyslogServer, calls decide for every evaluation and prints a table of the results
+func Replay(w io.Writer, cfg *config.Config, wm *whitelist.WhitelistManager, opts ReplayOptions, samples io.Reader, decide ReplayDecider) error {
+	if opts.Parser != "" && !isKnownParser(opts.Parser) {
+		return fmt.Errorf("unknown parser %q", opts.Parser)
+	}
+	p, err := newPipeline(cfg, wm, nil)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tSRC\tDST\tPARSER\tACTION\tDECISION")
+
+	scanner := bufio.NewScanner(samples)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo, evaluated, failed := 0, 0, 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		logParts := parseLine(line)
+		logParts["raw"] = line
+		if opts.Sender != "" {
+			logParts["client"] = opts.Sender
+		}
+
+		if !p.senders.IsAllowed(opts.Sender) {
+			fmt.Fprintf(tw, "%d\t-\t-\t-\t-\trejected: sender not allowed\n", lineNo)
+			continue
+		}
+
+		parsed := p.parse(logParts, opts.Sender, opts.Parser)
+		var decision string
+		switch evaluations := p.evaluations(parsed); {
+		case parsed.Failure != "":
+			failed++
+			decision = "skipped: " + parsed.Failure
+		case len(evaluations) == 0:
+			decision = "skipped: whitelisted"
+		default:
+			evaluated++
+			var decisions []string
+			for _, e := range evaluations {
+				decisions = append(decisions, e.Direction+" "+decide(e.Direction, e.IP, e.RelatedIP, parsed.Source))
+			}
+			decision = strings.Join(decisions, "; ")
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", lineNo,
+			orDash(parsed.Src), orDash(parsed.Dst), orDash(parsed.Parser), orDash(parsed.Source.Action), decision)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read samples: %w", err)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n%d lines, %d evaluated, %d could not be parsed\n", lineNo, evaluated, failed)
+	return nil
+}
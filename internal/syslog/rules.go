@@ -0,0 +1,288 @@
+package syslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const rulesReloadInterval = 10 * time.Second
+
+// Capture names understood by extraction rules
+const (
+	CaptureSrc    = "src"
+	CaptureDst    = "dst"
+	CaptureSport  = "sport"
+	CaptureDport  = "dport"
+	CaptureProto  = "proto"
+	CaptureAction = "action"
+)
+
+// grokPatterns are the named patterns usable as %{NAME} or %{NAME:capture} in rule patterns
+var grokPatterns = map[string]string{
+	"IP":           ipPattern,
+	"IPV4":         ipv4Pattern,
+	"IPV6":         ipv6Pattern,
+	"INT":          `[+-]?\d+`,
+	"POSINT":       `\d+`,
+	"NUMBER":       `[+-]?\d+(?:\.\d+)?`,
+	"WORD":         `\w+`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"`,
+	"HOSTNAME":     `[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?`,
+	"IPORHOST":     `(?:` + ipPattern + `|[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?)`,
+}
+
+var grokRe = regexp.MustCompile(`%\{(\w+)(?::(\w+))?\}`)
+
+// ExtractionRule is a user-defined pattern for log formats no built-in parser understands
+type ExtractionRule struct {
+	Name string `json:"name"`
+	// Pattern is a grok-style pattern (%{IP:src}) or a regex with named groups ((?P<src>...))
+	Pattern string `json:"pattern"`
+	// Programs restricts the rule to messages whose tag/app_name is one of these
+	Programs []string `json:"programs"`
+	// Senders restricts the rule to messages from these sender CIDRs
+	Senders []string `json:"senders"`
+
+	re         *regexp.Regexp
+	senderNets []*net.IPNet
+}
+
+type rulesFile struct {
+	Rules []ExtractionRule `json:"rules"`
+}
+
+// RuleMatch holds the values captured by a matching rule
+type RuleMatch struct {
+	Rule     string
+	Captures map[string]string
+}
+
+// compileGrok expands %{NAME:capture} references into a Go regular expression
+func compileGrok(pattern string) (*regexp.Regexp, error) {
+	var expandErr error
+	expanded := grokRe.ReplaceAllStringFunc(pattern, func(ref string) string {
+		m := grokRe.FindStringSubmatch(ref)
+		sub, ok := grokPatterns[m[1]]
+		if !ok {
+			expandErr = fmt.Errorf("unknown grok pattern %q", m[1])
+			return ref
+		}
+		if m[2] == "" {
+			return `(?:` + sub + `)`
+		}
+		return `(?P<` + m[2] + `>` + sub + `)`
+	})
+	if expandErr != nil {
+		return nil, expandErr
+	}
+	return regexp.Compile(expanded)
+}
+
+func (r *ExtractionRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without name")
+	}
+
+	re, err := compileGrok(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	if re.SubexpIndex(CaptureSrc) < 0 || re.SubexpIndex(CaptureDst) < 0 {
+		return fmt.Errorf("rule %q: pattern must capture %q and %q", r.Name, CaptureSrc, CaptureDst)
+	}
+	r.re = re
+
+	r.senderNets = nil
+	for _, cidr := range r.Senders {
+		network, err := parseSenderCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		r.senderNets = append(r.senderNets, network)
+	}
+	return nil
+}
+
+// applies reports whether the rule's program and sender conditions hold
+func (r *ExtractionRule) applies(program, sender string) bool {
+	if len(r.Programs) > 0 {
+		found := false
+		for _, p := range r.Programs {
+			if strings.EqualFold(p, program) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.senderNets) > 0 {
+		ip := net.ParseIP(sender)
+		if ip == nil {
+			return false
+		}
+		for _, network := range r.senderNets {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (r *ExtractionRule) match(msg string) (map[string]string, bool) {
+	m := r.re.FindStringSubmatch(msg)
+	if m == nil {
+		return nil, false
+	}
+	captures := make(map[string]string)
+	for i, name := range r.re.SubexpNames() {
+		if name != "" && m[i] != "" {
+			captures[name] = m[i]
+		}
+	}
+	return captures, true
+}
+
+// LoadRules reads and compiles a rules file
+func LoadRules(path string) ([]ExtractionRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+	var file rulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode rules file: %w", err)
+	}
+	for i := range file.Rules {
+		if err := file.Rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return file.Rules, nil
+}
+
+// MatchRules returns the first rule matching the message
+func MatchRules(rules []ExtractionRule, program, sender, msg string) (RuleMatch, bool) {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.applies(program, sender) {
+			continue
+		}
+		if captures, ok := rule.match(msg); ok {
+			return RuleMatch{Rule: rule.Name, Captures: captures}, true
+		}
+	}
+	return RuleMatch{}, false
+}
+
+// RuleStore holds the current rule set and reloads it when the file changes
+type RuleStore struct {
+	path string
+
+	mu      sync.RWMutex
+	rules   []ExtractionRule
+	modTime time.Time
+}
+
+// NewRuleStore loads the rules file. An empty path yields a store without rules.
+func NewRuleStore(path string) (*RuleStore, error) {
+	s := &RuleStore{path: path}
+	if path == "" {
+		return s, nil
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RuleStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat rules file: %w", err)
+	}
+	rules, err := LoadRules(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.rules = rules
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+
+	zap.L().Info("Loaded syslog extraction rules",
+		zap.String("file", s.path),
+		zap.Int("count", len(rules)),
+	)
+	return nil
+}
+
+// Watch polls the rules file and reloads it on change until ctx is done
+func (s *RuleStore) Watch(ctx context.Context) {
+	if s.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(rulesReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				continue
+			}
+			s.mu.RLock()
+			unchanged := info.ModTime().Equal(s.modTime)
+			s.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+			if err := s.reload(); err != nil {
+				// Keep the previous rules until the file is valid again
+				zap.L().Error("Failed to reload syslog extraction rules", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Match returns the first matching rule of the current rule set
+func (s *RuleStore) Match(program, sender, msg string) (RuleMatch, bool) {
+	s.mu.RLock()
+	rules := s.rules
+	s.mu.RUnlock()
+
+	return MatchRules(rules, program, sender, msg)
+}
+
+// programFromLogParts returns the RFC 3164 tag or RFC 5424 app name
+func programFromLogParts(logParts map[string]interface{}) string {
+	if tag, ok := logParts["tag"].(string); ok && tag != "" {
+		return tag
+	}
+	if appName, ok := logParts["app_name"].(string); ok && appName != "-" {
+		return appName
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// parseLine parses a raw RFC 3164/5424 line into logParts.
+// Lines that are not valid syslog are treated as bare message bodies.
+func parseLine(line string) map[string]interface{} {
+	parser := syslog.Automatic.GetParser([]byte(line))
+	if err := parser.Parse(); err == nil {
+		return parser.Dump()
+	}
+	return map[string]interface{}{"content": line}
+}
+
+// TestRules runs every sample line through the rules and prints a table of the captures
+func TestRules(w io.Writer, rules []ExtractionRule, program, sender string, samples io.Reader) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tRULE\tSRC\tDST\tSPORT\tDPORT\tPROTO\tACTION")
+
+	scanner := bufio.NewScanner(samples)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	matched := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		logParts := parseLine(line)
+		msg, _ := extractMessage(logParts)
+		lineProgram := program
+		if lineProgram == "" {
+			lineProgram = programFromLogParts(logParts)
+		}
+
+		match, ok := MatchRules(rules, lineProgram, sender, msg)
+		if !ok {
+			fmt.Fprintf(tw, "%d\t-\t-\t-\t-\t-\t-\t-\n", lineNo)
+			continue
+		}
+		matched++
+
+		c := match.Captures
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", lineNo, match.Rule,
+			orDash(c[CaptureSrc]), orDash(c[CaptureDst]), orDash(c[CaptureSport]),
+			orDash(c[CaptureDport]), orDash(c[CaptureProto]), orDash(c[CaptureAction]))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read samples: %w", err)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n%d of %d lines matched\n", matched, lineNo)
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,83 @@
+package syslog
+
+import (
+	"regexp"
+
+	"go.uber.org/zap"
+)
+
+// Event categories reported for single-IP security events
+const (
+	CategorySSHAuthFailure  = "ssh-auth-failure"
+	CategorySSHProbe        = "ssh-probe"
+	CategorySMTPAuthFailure = "smtp-auth-failure"
+	CategorySMTPReject      = "smtp-reject"
+	CategoryMailAuthFailure = "mail-auth-failure"
+	CategoryVPNAuthFailure  = "vpn-auth-failure"
+	CategoryWebAuthFailure  = "web-auth-failure"
+	CategoryWebAccessDenied = "web-access-denied"
+	CategoryWebWAFBlock     = "web-waf-block"
+	CategoryWebRateLimit    = "web-rate-limit"
+)
+
+// securityEventRule matches a log line that names a single offending IP.
+// The first capture group of Pattern must be the IP.
+type securityEventRule struct {
+	Parser   string
+	Category string
+	Pattern  *regexp.Regexp
+}
+
+var securityEventRules = []securityEventRule{
+	// sshd
+	{"sshd", CategorySSHAuthFailure, regexp.MustCompile(`Failed (?:password|publickey|keyboard-interactive/pam|none) for (?:invalid user )?\S* ?from (` + ipPattern + `) port \d+`)},
+	{"sshd", CategorySSHAuthFailure, regexp.MustCompile(`[Ii]nvalid user \S* ?from (` + ipPattern + `)`)},
+	{"sshd", CategorySSHAuthFailure, regexp.MustCompile(`maximum authentication attempts exceeded for .* from (` + ipPattern + `) port \d+`)},
+	{"sshd", CategorySSHAuthFailure, regexp.MustCompile(`Connection (?:closed|reset) by (?:authenticating|invalid) user \S* ?(` + ipPattern + `) port \d+`)},
+	{"sshd", CategorySSHProbe, regexp.MustCompile(`Did not receive identification string from (` + ipPattern + `)`)},
+	{"sshd", CategorySSHProbe, regexp.MustCompile(`Unable to negotiate with (` + ipPattern + `) port \d+`)},
+	{"sshd", CategorySSHProbe, regexp.MustCompile(`banner exchange: Connection from (` + ipPattern + `) port \d+: invalid format`)},
+
+	// Postfix
+	{"postfix", CategorySMTPAuthFailure, regexp.MustCompile(`warning: [^\[\s]*\[(` + ipPattern + `)\]: SASL \S+ authentication failed`)},
+	{"postfix", CategorySMTPAuthFailure, regexp.MustCompile(`lost connection after AUTH from [^\[\s]*\[(` + ipPattern + `)\]`)},
+	{"postfix", CategorySMTPReject, regexp.MustCompile(`NOQUEUE: reject: RCPT from [^\[\s]*\[(` + ipPattern + `)\]: 5\d\d `)},
+
+	// Dovecot
+	{"dovecot", CategoryMailAuthFailure, regexp.MustCompile(`(?:imap|pop3|submission|managesieve)-login: (?:Disconnected|Aborted login|Login aborted)[^(]*\((?:auth failed|tried to use disallowed|no auth attempts)[^)]*\).*rip=(` + ipPattern + `)`)},
+	{"dovecot", CategoryMailAuthFailure, regexp.MustCompile(`auth(?:-worker)?(?:\(\d+\))?: \w+(?:-\w+)*\([^,]*,(` + ipPattern + `)(?:,[^)]*)?\): (?:unknown user|[Pp]assword mismatch|pam_authenticate\(\) failed)`)},
+
+	// OpenVPN
+	{"openvpn", CategoryVPNAuthFailure, regexp.MustCompile(`(?:\S+/)?(` + ipPattern + `):\d+ (?:TLS Auth Error|VERIFY ERROR|TLS Error: TLS handshake failed|WARNING: Failed running command \(--auth-user-pass-verify\))`)},
+	{"openvpn", CategoryVPNAuthFailure, regexp.MustCompile(`(?:\S+/)?(` + ipPattern + `):\d+ .*(?:AUTH_FAILED|Auth Username/Password verification failed)`)},
+
+	// nginx error log
+	{"nginx", CategoryWebWAFBlock, regexp.MustCompile(`ModSecurity: Access denied .*client: (` + ipPattern + `)`)},
+	{"nginx", CategoryWebAuthFailure, regexp.MustCompile(`(?:no user/password was provided for basic authentication|user "[^"]*" was not found in|user "[^"]*": password mismatch), client: (` + ipPattern + `)`)},
+	{"nginx", CategoryWebAccessDenied, regexp.MustCompile(`access forbidden by rule, client: (` + ipPattern + `)`)},
+	{"nginx", CategoryWebRateLimit, regexp.MustCompile(`limiting (?:requests|connections)(?:, dry run)?, .*client: (` + ipPattern + `)`)},
+
+	// Apache error log
+	{"apache", CategoryWebWAFBlock, regexp.MustCompile(`\[client (` + ipPattern + `)(?::\d+)?\] ModSecurity: Access denied`)},
+	{"apache", CategoryWebAuthFailure, regexp.MustCompile(`\[client (` + ipPattern + `)(?::\d+)?\] (?:AH01617|AH01618|AH01790|AH01807): `)},
+	{"apache", CategoryWebAccessDenied, regexp.MustCompile(`\[client (` + ipPattern + `)(?::\d+)?\] (?:AH01630|AH01797|AH01071): `)},
+}
+
+// extractSecurityEvent matches msg against the single-IP security event rules.
+// Returns the offending IP, its category and the name of the matching parser.
+func extractSecurityEvent(msg string) (ip, category, parser string) {
+	for _, rule := range securityEventRules {
+		match := rule.Pattern.FindStringSubmatch(msg)
+		if len(match) < 2 {
+			continue
+		}
+		zap.L().Debug("Extracted single-IP security event",
+			zap.String("parser", rule.Parser),
+			zap.String("category", rule.Category),
+			zap.String("ip", match[1]),
+			zap.String("msg", msg),
+		)
+		return match[1], rule.Category, rule.Parser
+	}
+	return "", "", ""
+}
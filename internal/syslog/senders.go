@@ -0,0 +1,239 @@
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yl2chen/cidranger"
+	"go.uber.org/zap"
+)
+
+// maxTrackedRejectedSenders bounds the per-sender rejection counters, spoofed UDP
+// sources beyond this are counted under "other"
+const maxTrackedRejectedSenders = 1000
+
+// SenderBinding assigns a friendly name, site and optionally a fixed parser to a sender CIDR
+type SenderBinding struct {
+	CIDR   string `json:"cidr"`
+	Name   string `json:"name"`
+	Site   string `json:"site"`
+	Parser string `json:"parser"`
+}
+
+type sendersFile struct {
+	Senders []SenderBinding `json:"senders"`
+}
+
+type senderBindingEntry struct {
+	network net.IPNet
+	binding SenderBinding
+}
+
+func (e *senderBindingEntry) Network() net.IPNet {
+	return e.network
+}
+
+// SenderRegistry decides which senders may submit syslog messages and how they are labelled
+type SenderRegistry struct {
+	allowed  cidranger.Ranger
+	bindings cidranger.Ranger
+	// restricted is true if an allowlist is configured, otherwise every sender is accepted
+	restricted bool
+
+	mu       sync.Mutex
+	rejected map[string]uint64
+}
+
+// NewSenderRegistry builds a registry from a list of allowed CIDRs and an optional JSON bindings file
+func NewSenderRegistry(allowedCIDRs []string, bindingsFile string) (*SenderRegistry, error) {
+	r := &SenderRegistry{
+		allowed:  cidranger.NewPCTrieRanger(),
+		bindings: cidranger.NewPCTrieRanger(),
+		rejected: make(map[string]uint64),
+	}
+
+	for _, cidr := range allowedCIDRs {
+		network, err := parseSenderCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		r.allowed.Insert(cidranger.NewBasicRangerEntry(*network))
+		r.restricted = true
+	}
+
+	if bindingsFile == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(bindingsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read syslog senders file: %w", err)
+	}
+	var file sendersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode syslog senders file: %w", err)
+	}
+
+	for _, binding := range file.Senders {
+		network, err := parseSenderCIDR(binding.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		if binding.Parser != "" && !isKnownParser(binding.Parser) {
+			return nil, fmt.Errorf("unknown parser %q for sender %s", binding.Parser, binding.CIDR)
+		}
+		r.bindings.Insert(&senderBindingEntry{network: *network, binding: binding})
+		// Bound senders are implicitly allowed
+		if r.restricted {
+			r.allowed.Insert(cidranger.NewBasicRangerEntry(*network))
+		}
+	}
+
+	zap.L().Info("Loaded syslog sender bindings",
+		zap.String("file", bindingsFile),
+		zap.Int("count", len(file.Senders)),
+	)
+	return r, nil
+}
+
+// parseSenderCIDR accepts a CIDR or a plain IP, which is treated as a single host
+func parseSenderCIDR(cidr string) (*net.IPNet, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid sender address %q", cidr)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender CIDR %q: %w", cidr, err)
+	}
+	return network, nil
+}
+
+// IsAllowed reports whether the sender may submit messages, counting rejections
+func (r *SenderRegistry) IsAllowed(sender string) bool {
+	if !r.restricted {
+		return true
+	}
+
+	ip := net.ParseIP(sender)
+	if ip != nil {
+		if ok, err := r.allowed.Contains(ip); err == nil && ok {
+			return true
+		}
+	}
+
+	r.mu.Lock()
+	key := sender
+	if _, tracked := r.rejected[key]; !tracked && len(r.rejected) >= maxTrackedRejectedSenders {
+		key = "other"
+	}
+	r.rejected[key]++
+	count := r.rejected[key]
+	r.mu.Unlock()
+
+	if count == 1 {
+		zap.L().Warn("Rejected syslog message from sender not in allowlist",
+			zap.String("sender", sender),
+		)
+	}
+	return false
+}
+
+// Lookup returns the most specific binding for the sender, if any
+func (r *SenderRegistry) Lookup(sender string) (SenderBinding, bool) {
+	ip := net.ParseIP(sender)
+	if ip == nil {
+		return SenderBinding{}, false
+	}
+
+	entries, err := r.bindings.ContainingNetworks(ip)
+	if err != nil || len(entries) == 0 {
+		return SenderBinding{}, false
+	}
+
+	best := entries[0]
+	for _, entry := range entries[1:] {
+		bestNet, entryNet := best.Network(), entry.Network()
+		bestOnes, _ := bestNet.Mask.Size()
+		entryOnes, _ := entryNet.Mask.Size()
+		if entryOnes > bestOnes {
+			best = entry
+		}
+	}
+	return best.(*senderBindingEntry).binding, true
+}
+
+// RejectedCounts returns a snapshot of rejected message counts by sender
+func (r *SenderRegistry) RejectedCounts() map[string]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]uint64, len(r.rejected))
+	for sender, count := range r.rejected {
+		counts[sender] = count
+	}
+	return counts
+}
+
+// logRejected logs the aggregated rejection counters
+func (r *SenderRegistry) logRejected() {
+	counts := r.RejectedCounts()
+	if len(counts) == 0 {
+		return
+	}
+
+	senders := make([]string, 0, len(counts))
+	var total uint64
+	for sender, count := range counts {
+		senders = append(senders, sender)
+		total += count
+	}
+	sort.Slice(senders, func(i, j int) bool { return counts[senders[i]] > counts[senders[j]] })
+	if len(senders) > 10 {
+		senders = senders[:10]
+	}
+
+	top := make([]string, 0, len(senders))
+	for _, sender := range senders {
+		top = append(top, fmt.Sprintf("%s=%d", sender, counts[sender]))
+	}
+
+	zap.L().Info("Syslog messages rejected by sender allowlist",
+		zap.Uint64("total", total),
+		zap.Int("senders", len(counts)),
+		zap.Strings("topSenders", top),
+	)
+}
+
+// senderFromLogParts returns the IP of the client that sent the message
+func senderFromLogParts(logParts map[string]interface{}) string {
+	var sourceAddr string
+	switch client := logParts["client"].(type) {
+	case string:
+		sourceAddr = client
+	case net.Addr:
+		sourceAddr = client.String()
+	}
+	if sourceAddr == "" {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(sourceAddr); err == nil {
+		return host
+	}
+	return sourceAddr
+}
@@ -2,97 +2,192 @@ package syslog
 
 import (
 	"context"
-	"fmt"
-	"net"
 	"sync"
+	"time"
 
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
-	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/recommender"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/types"
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/internal/whitelist"
 	"go.uber.org/zap"
 	"gopkg.in/mcuadros/go-syslog.v2"
 )
 
+const rejectedSendersLogInterval = 5 * time.Minute
+
 func StartSyslogServer(ctx context.Context, cfg *config.Config, whitelistManager *whitelist.WhitelistManager, evaluationFunc types.EvaluationFunc, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	zap.L().Info("Starting Syslog Server",
-		zap.String("protocol", "udp"),
-		zap.String("address", fmt.Sprintf("%s:%d", cfg.SyslogListenAddr, cfg.SyslogPort)),
-	)
-	zap.L().Info("Starting Syslog Server",
-		zap.String("protocol", "tcp"),
-		zap.String("address", fmt.Sprintf("%s:%d", cfg.SyslogListenAddr, cfg.SyslogPort)),
-	)
+	p, err := newPipeline(cfg, whitelistManager, evaluationFunc)
+	if err != nil {
+		zap.L().Error("Invalid syslog configuration, not starting syslog server", zap.Error(err))
+		return
+	}
+	listeners, err := LoadListeners(cfg)
+	if err != nil {
+		zap.L().Error("Invalid syslog listener configuration, not starting syslog server", zap.Error(err))
+		return
+	}
+	channel := make(chan receivedMessage)
+	ingest, err := newHTTPIngest(cfg, channel)
+	if err != nil {
+		zap.L().Error("Invalid HTTP ingestion configuration, not starting syslog server", zap.Error(err))
+		return
+	}
+	gelf, err := newGELFInput(cfg, channel, p.senders)
+	if err != nil {
+		zap.L().Error("Invalid GELF configuration, not starting syslog server", zap.Error(err))
+		return
+	}
+	go p.rules.Watch(ctx)
+	p.forwarder.Start(ctx)
 
-	channel := make(syslog.LogPartsChannel)
-	handler := syslog.NewChannelHandler(channel)
+	// TLS listeners share one certificate, which is reloaded when it changes on disk
+	needsTLS := ingest != nil && cfg.HTTPIngestTLS
+	for _, l := range listeners {
+		needsTLS = needsTLS || l.Protocol == ProtocolTLS
+	}
+	var tlsReloader *certReloader
+	if needsTLS {
+		reloader, err := newCertReloader(cfg.SyslogTLSCertFile, cfg.SyslogTLSKeyFile, cfg.SyslogTLSClientCAFile)
+		if err != nil {
+			zap.L().Error("Failed to load syslog TLS certificate, TLS listeners disabled", zap.Error(err))
+		} else {
+			tlsReloader = reloader
+			go tlsReloader.watch(ctx)
+		}
+	}
 
-	server := syslog.NewServer()
-	server.SetFormat(syslog.Automatic)
-	server.SetHandler(handler)
-	server.ListenUDP(fmt.Sprintf("%s:%d", cfg.SyslogListenAddr, cfg.SyslogPort))
-	server.ListenTCP(fmt.Sprintf("%s:%d", cfg.SyslogListenAddr, cfg.SyslogPort))
-	server.Boot()
+	// Each listener gets its own server, as go-syslog applies one format (framing) per server
+	var servers []*syslog.Server
+	for i := range listeners {
+		l := &listeners[i]
+		server := syslog.NewServer()
+		server.SetFormat(l.format())
+		server.SetHandler(&listenerHandler{listener: l, channel: channel})
+		if err := l.listen(server, tlsReloader); err != nil {
+			zap.L().Error("Failed to start syslog listener",
+				zap.String("listener", l.Name),
+				zap.String("protocol", l.Protocol),
+				zap.String("address", l.Endpoint()),
+				zap.Error(err),
+			)
+			continue
+		}
+		if err := server.Boot(); err != nil {
+			zap.L().Error("Failed to boot syslog listener",
+				zap.String("listener", l.Name),
+				zap.Error(err),
+			)
+			continue
+		}
+		servers = append(servers, server)
+
+		fields := []zap.Field{
+			zap.String("listener", l.Name),
+			zap.String("protocol", l.Protocol),
+			zap.String("address", l.Endpoint()),
+			zap.String("framing", l.Framing),
+			zap.String("parser", l.Parser),
+			zap.Int("maxMessageSize", l.MaxMessageSize),
+		}
+		if l.Protocol == ProtocolTLS {
+			fields = append(fields, zap.Bool("requireClientCert", cfg.SyslogTLSClientCAFile != ""))
+		}
+		zap.L().Info("Starting Syslog Server", fields...)
+	}
+
+	var ingestDone chan struct{}
+	if ingest != nil {
+		ingestDone = make(chan struct{})
+		zap.L().Info("Starting HTTP event ingestion",
+			zap.String("address", cfg.HTTPIngestListenAddr),
+			zap.Bool("tls", cfg.HTTPIngestTLS),
+			zap.String("parser", cfg.HTTPIngestParser),
+			zap.Int("tokens", len(ingest.tokens)),
+		)
+		go func() {
+			defer close(ingestDone)
+			if err := ingest.serve(ctx, tlsReloader, cfg.HTTPIngestTLS); err != nil {
+				zap.L().Error("HTTP event ingestion failed",
+					zap.String("address", cfg.HTTPIngestListenAddr),
+					zap.Error(err),
+				)
+			}
+		}()
+	}
+
+	var gelfDone chan struct{}
+	if gelf != nil {
+		gelfDone = make(chan struct{})
+		zap.L().Info("Starting GELF listener",
+			zap.String("address", gelf.listener.Endpoint()),
+			zap.String("parser", cfg.GelfParser),
+		)
+		go func() {
+			defer close(gelfDone)
+			if err := gelf.serve(ctx); err != nil {
+				zap.L().Error("GELF listener failed",
+					zap.String("address", gelf.listener.Endpoint()),
+					zap.Error(err),
+				)
+			}
+		}()
+	}
+
+	if len(servers) == 0 && ingest == nil && gelf == nil {
+		zap.L().Error("No syslog listener could be started")
+		return
+	}
 
 	// Goroutine to handle log messages
-	go func(channel syslog.LogPartsChannel) {
+	go func() {
+		statsTicker := time.NewTicker(rejectedSendersLogInterval)
+		defer statsTicker.Stop()
+
 		for {
 			select {
 			case <-ctx.Done():
 				zap.L().Info("Syslog server stopping: context canceled")
 				return
-			case logParts, ok := <-channel:
-				if !ok {
-					return
+			case <-statsTicker.C:
+				p.senders.logRejected()
+				p.forwarder.logStats()
+				p.clockSkew.log()
+				ingest.logStats()
+				gelf.logStats()
+				for i := range listeners {
+					listeners[i].stats.log()
 				}
+			case msg := <-channel:
 				zap.L().Debug("Received syslog message",
-					zap.Any("logParts", logParts),
+					zap.String("listener", msg.listener.Name),
+					zap.Any("logParts", msg.logParts),
 				)
-				src, dst, _ := inferSrcDst(logParts)
-
-				// Early exit if no valid IPs were extracted
-				// Empty strings mean either no IPs found or IPs were filtered as invalid
-				if src == "" || dst == "" {
-					zap.L().Debug("Skipping message: no valid source/destination",
-						zap.String("src", src),
-						zap.String("dst", dst),
-					)
-					continue
-				}
-
-				if !recommender.ShouldProcessPacket(whitelistManager, src, dst) {
-					zap.L().Debug("Skipping message: filtered by whitelist",
-						zap.String("src", src),
-						zap.String("dst", dst),
-					)
-					continue
-				}
-
-				sourceAddr := "unknown"
-				if client, ok := logParts["client"]; ok {
-					if addr, ok := client.(net.Addr); ok {
-						sourceAddr = addr.String()
-						if host, _, err := net.SplitHostPort(sourceAddr); err == nil {
-							sourceAddr = host
-						}
-					}
-				}
-
-				go evaluationFunc(cfg, "source", src, dst, types.Source{SourceType: "syslog", SourceName: sourceAddr})
-				go evaluationFunc(cfg, "destination", dst, src, types.Source{SourceType: "syslog", SourceName: sourceAddr})
+				p.process(msg.logParts, msg.listener)
 			}
 		}
-	}(channel)
+	}()
 
 	// Wait for stop signal
 	go func() {
 		<-ctx.Done()
 		zap.L().Info("Shutting down syslog server")
-		server.Kill()
+		for _, server := range servers {
+			server.Kill()
+		}
 	}()
 
-	server.Wait()
+	for _, server := range servers {
+		server.Wait()
+	}
+	if ingestDone != nil {
+		<-ingestDone
+	}
+	if gelfDone != nil {
+		<-gelfDone
+	}
+	for i := range listeners {
+		listeners[i].close()
+	}
 	zap.L().Info("Syslog server exited cleanly")
 }
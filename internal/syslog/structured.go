@@ -0,0 +1,207 @@
+package syslog
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// fieldMapping names the paths holding the source and destination IP in structured logs.
+// Paths are dot separated and matched case-insensitively, e.g. "source.ip".
+// A path also matches nested below other objects, see lookupField.
+type fieldMapping struct {
+	Name string
+	Src  string
+	Dst  string
+}
+
+// builtinFieldMappings are tried in order after any custom mappings
+var builtinFieldMappings = []fieldMapping{
+	{"ecs", "source.ip", "destination.ip"},
+	{"ecs-address", "source.address", "destination.address"},
+	{"ocsf", "src_endpoint.ip", "dst_endpoint.ip"},
+	{"src_ip", "src_ip", "dst_ip"},
+	{"source_ip", "source_ip", "destination_ip"},
+	{"sourceip", "sourceip", "destinationip"},
+	{"srcip", "srcip", "dstip"},
+	{"src_addr", "src_addr", "dst_addr"},
+	{"source_address", "source_address", "destination_address"},
+	{"saddr", "saddr", "daddr"},
+	{"client_ip", "client_ip", "server_ip"},
+	{"src", "src", "dst"},
+}
+
+// parseFieldMappings parses custom mappings given as "srcPath=dstPath"
+func parseFieldMappings(specs []string) ([]fieldMapping, error) {
+	var mappings []fieldMapping
+	for _, spec := range specs {
+		src, dst, ok := strings.Cut(spec, "=")
+		src, dst = strings.TrimSpace(src), strings.TrimSpace(dst)
+		if !ok || src == "" || dst == "" {
+			return nil, fmt.Errorf("invalid field mapping %q, expected srcPath=dstPath", spec)
+		}
+		mappings = append(mappings, fieldMapping{Name: "custom", Src: src, Dst: dst})
+	}
+	return mappings, nil
+}
+
+// lookupField returns the value at path, or else the value of the shortest
+// path ending in path, so mappings also match inside envelopes such as "event.src_ip"
+func lookupField(fields map[string]string, path string) (string, bool) {
+	path = strings.ToLower(path)
+	if value, ok := fields[path]; ok {
+		return value, true
+	}
+
+	best := ""
+	for key := range fields {
+		if !strings.HasSuffix(key, "."+path) {
+			continue
+		}
+		if best == "" || len(key) < len(best) || (len(key) == len(best) && key < best) {
+			best = key
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+	return fields[best], true
+}
+
+// mappedSrcDst returns the first src/dst pair found via the custom and then the built-in field mappings
+func mappedSrcDst(fields map[string]string, custom []fieldMapping) (src, dst string) {
+	for _, mapping := range slices.Concat(custom, builtinFieldMappings) {
+		srcVal, srcOk := lookupField(fields, mapping.Src)
+		dstVal, dstOk := lookupField(fields, mapping.Dst)
+		if !srcOk || !dstOk {
+			continue
+		}
+
+		srcIP, srcValid := normalizeIPWithPort(srcVal)
+		dstIP, dstValid := normalizeIPWithPort(dstVal)
+		if !srcValid || !dstValid {
+			continue
+		}
+
+		zap.L().Debug("Extracted source and destination using field mapping",
+			zap.String("mapping", mapping.Name),
+			zap.String("srcPath", mapping.Src),
+			zap.String("dstPath", mapping.Dst),
+			zap.String("src", srcIP),
+			zap.String("dst", dstIP),
+		)
+		return srcIP, dstIP
+	}
+	return "", ""
+}
+
+// flattenJSON collects all scalar values by their lowercased dot separated path.
+// Keys which already contain dots (flattened ECS) produce the same paths as nested objects.
+// For arrays and duplicate paths the first value wins.
+func flattenJSON(v interface{}, prefix string, out map[string]string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(val) {
+			path := strings.ToLower(key)
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			flattenJSON(val[key], path, out)
+		}
+	case []interface{}:
+		for _, item := range val {
+			flattenJSON(item, prefix, out)
+		}
+	case string:
+		if _, exists := out[prefix]; !exists {
+			out[prefix] = val
+		}
+	}
+}
+
+// flattenXML collects element text by lowercased dot separated path, excluding the root element.
+// Elements with a Name attribute (e.g. Windows <Data Name="IpAddress">) use it as their path segment.
+func flattenXML(msg string) map[string]string {
+	out := make(map[string]string)
+	decoder := xml.NewDecoder(strings.NewReader(msg))
+	decoder.Strict = false
+
+	var path []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return out
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			for _, attr := range t.Attr {
+				if strings.EqualFold(attr.Name.Local, "Name") && attr.Value != "" {
+					name = attr.Value
+				}
+			}
+			path = append(path, strings.ToLower(name))
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" || len(path) < 2 {
+				continue
+			}
+			key := strings.Join(path[1:], ".")
+			if _, exists := out[key]; !exists {
+				out[key] = text
+			}
+		}
+	}
+	return out
+}
+
+// extractJSONSrcDst extracts src/dst using the field mappings, falling back to a generic walk
+func extractJSONSrcDst(msg string, custom []fieldMapping) []string {
+	var data interface{}
+	if err := json.Unmarshal([]byte(msg), &data); err != nil {
+		return nil
+	}
+
+	fields := make(map[string]string)
+	flattenJSON(data, "", fields)
+	if src, dst := mappedSrcDst(fields, custom); src != "" && dst != "" {
+		return []string{src, dst}
+	}
+
+	zap.L().Debug("No field mapping matched JSON message, falling back to generic walk")
+	return extractIPsFromJSON(msg)
+}
+
+// extractXMLSrcDst extracts src/dst using the field mappings, falling back to all element values
+func extractXMLSrcDst(msg string, custom []fieldMapping) []string {
+	if src, dst := mappedSrcDst(flattenXML(msg), custom); src != "" && dst != "" {
+		return []string{src, dst}
+	}
+
+	zap.L().Debug("No field mapping matched XML message, falling back to generic walk")
+	return extractIPsFromXML(msg)
+}
+
+// sortedKeys returns the keys of m in lexical order so JSON walks are deterministic
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,159 @@
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const certReloadInterval = 30 * time.Second
+
+// certReloader serves the syslog TLS certificate and client CA pool,
+// reloading them whenever the files change on disk
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+// reload reads the certificate, key and client CA bundle from disk
+func (r *certReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load syslog TLS key pair: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.caFile != "" {
+		caPEM, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read syslog client CA file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no valid certificates found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	zap.L().Info("Loaded syslog TLS certificate",
+		zap.String("certFile", r.certFile),
+		zap.String("clientCAFile", r.caFile),
+	)
+	return nil
+}
+
+// changed reports whether any of the watched files has a new modification time
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch polls the certificate files and reloads them on change until ctx is done
+func (r *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				// Keep serving the previous certificate until the files are valid again
+				zap.L().Error("Failed to reload syslog TLS certificate", zap.Error(err))
+			}
+		}
+	}
+}
+
+// tlsConfig returns a server config that always uses the most recently loaded certificates.
+// Client certificates are required and verified only if a client CA file is configured.
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCAs != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = r.clientCAs
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// tlsPeerName returns the client certificate CN. Unlike the go-syslog default it
+// accepts clients without a certificate, which is only possible when none is required.
+func tlsPeerName(tlsConn *tls.Conn) (string, bool) {
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return "", true
+	}
+	return state.PeerCertificates[0].Subject.CommonName, true
+}
@@ -211,8 +211,9 @@ func monitorInterface(ctx context.Context, cfg *config.Config, ifaceName string,
 			packetsProcessed++
 
 			// Process the connection
-			go evaluationFunc(cfg, "source", src, dst, types.Source{SourceType: "interface", SourceName: ifaceName})
-			go evaluationFunc(cfg, "destination", dst, src, types.Source{SourceType: "interface", SourceName: ifaceName})
+			source := types.Source{SourceType: "interface", SourceName: ifaceName, SrcPort: srcPort, DstPort: dstPort}
+			go evaluationFunc(cfg, "source", src, dst, source)
+			go evaluationFunc(cfg, "destination", dst, src, source)
 		}
 	}
 }
@@ -8,10 +8,27 @@ import (
 
 // Indicates the source on which we received a traffic event
 type Source struct {
-	SourceType string `json:"source_type"` // syslog or interface
-	SourceName string `json:"source_name"` // IP or iface name
+	SourceType string `json:"source_type"`        // syslog or interface
+	SourceName string `json:"source_name"`        // IP or iface name
+	Category   string `json:"category,omitempty"` // event category for single-IP security events
+	Site       string `json:"site,omitempty"`     // site of a known syslog sender
+	Action     string `json:"action,omitempty"`   // normalised firewall action, see ActionAllow
+	SrcPort    uint16 `json:"src_port,omitempty"` // source port of the flow, 0 if unknown
+	DstPort    uint16 `json:"dst_port,omitempty"` // destination port of the flow, 0 if unknown
+
+	// Metadata from the syslog header
+	Hostname  string     `json:"hostname,omitempty"`   // hostname reported by the device
+	Program   string     `json:"program,omitempty"`    // tag or app name
+	Severity  string     `json:"severity,omitempty"`   // severity keyword, e.g. warning
+	EventTime *time.Time `json:"event_time,omitempty"` // device-local event time
 }
 
+// Normalised firewall actions reported by syslog parsers
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
 type Decision struct {
 	Block     bool   `json:"block"`
 	Reason    string `json:"reason"`
@@ -35,6 +52,16 @@ type SyncResponse struct {
 	SniffTraffic   bool  `json:"sniffTraffic"`
 	RunSyslog      bool  `json:"runSyslog"`
 	AlertThreshold int32 `json:"alertThreshold"`
+	// BatchSubmission is true if the arbiter accepts alerts and recommendations in batches
+	BatchSubmission bool `json:"batchSubmission"`
+	// RateLimits are the arbiter's request budgets by endpoint, e.g. "/alert"
+	RateLimits map[string]RateLimit `json:"rateLimits,omitempty"`
+}
+
+// RateLimit allows Rate requests per second with bursts of up to Burst requests
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
 }
 
 type ScoreRecord struct {
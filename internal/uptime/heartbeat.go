@@ -1,86 +1,48 @@
 package uptime
 
 import (
+	"context"
 	"fmt"
-	"net/http"
-	"time"
+	neturl "net/url"
+	"strconv"
 
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/utils"
 	"go.uber.org/zap"
 )
 
-func SendHeartbeat(sensorName string, apikey string, identifier string, url string) error {
-	var resp *http.Response
-	var req *http.Request
-	var err error
-
-	maxRetries := 3
-	backoff := time.Second
-
-	zap.L().Info("Sending heartbeat")
-
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		req, err = http.NewRequest("GET", fmt.Sprintf("%s/ping/%s", url, identifier), nil)
-		if err != nil {
-			zap.L().Error("Failed to create request",
-				zap.Error(err),
-				zap.String("url", url),
-				zap.String("identifier", identifier),
-			)
-			return fmt.Errorf("failed to create request: %w", err)
-		}
-
-		req.Header.Set("apikey", apikey)
-
-		zap.L().Debug("heartbeat request", zap.Any("req:", req))
+// Status is the sensor state reported with each heartbeat
+type Status struct {
+	// Offline is true while the arbiter is unreachable
+	Offline bool
+	// Queued is the number of alerts and recommendations buffered for the arbiter
+	Queued int
+}
 
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			zap.L().Warn("Request failed, retrying",
-				zap.Int("attempt", attempt+1),
-				zap.String("url", url),
-				zap.String("identifier", identifier),
-				zap.Error(err),
-			)
-			if attempt < maxRetries {
-				time.Sleep(backoff)
-				backoff *= 2
-				continue
-			}
-			zap.L().Error("Failed to send request after retries",
-				zap.Int("maxRetries", maxRetries),
-				zap.String("url", url),
-				zap.String("identifier", identifier),
-				zap.Error(err),
-			)
-			return fmt.Errorf("failed to send request after retries: %w", err)
-		}
-		defer resp.Body.Close()
+func (s Status) query() string {
+	state := "online"
+	if s.Offline {
+		state = "offline"
+	}
+	return neturl.Values{"state": {state}, "queued": {strconv.Itoa(s.Queued)}}.Encode()
+}
 
-		if resp.StatusCode == http.StatusOK {
-			zap.L().Debug("Heartbeat request succeeded",
-				zap.Int("status", resp.StatusCode),
-			)
-			return nil // success
-		}
+// SendHeartbeat reports the sensor as alive to the uptime monitor
+func SendHeartbeat(ctx context.Context, cfg *config.Config, status Status) error {
+	zap.L().Info("Sending heartbeat",
+		zap.Bool("offline", status.Offline),
+		zap.Int("queued", status.Queued),
+	)
 
-		// Retry if status code is 5xx
-		if resp.StatusCode >= 500 && attempt < maxRetries {
-			zap.L().Warn("Server error, retrying",
-				zap.Int("attempt", attempt+1),
-				zap.String("url", url),
-				zap.String("identifier", identifier),
-				zap.Int("status", resp.StatusCode),
-			)
-			time.Sleep(backoff)
-			backoff *= 2
-			continue
-		}
+	client := utils.NewClient(cfg, cfg.HeartbeatUrl, map[string]string{"apikey": cfg.AuthSecret})
+	resp, err := client.DoRequest(ctx, utils.RequestOptions{
+		Endpoint: fmt.Sprintf("/ping/%s?%s", cfg.HeartbeatIdentifier, status.query()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
 	}
+	resp.Body.Close()
 
-	zap.L().Error("Request failed after all attempts",
-		zap.Int("maxRetries", maxRetries),
-		zap.String("url", url),
-		zap.String("identifier", identifier),
-	)
-	return fmt.Errorf("request failed after %d attempts", maxRetries)
+	zap.L().Debug("Heartbeat request succeeded", zap.Int("status", resp.StatusCode))
+	return nil
 }
@@ -1,6 +1,7 @@
 package whitelist
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -13,10 +14,10 @@ import (
 )
 
 // Sync fetches the whitelist from the API and updates the manager.
-func (wm *WhitelistManager) Sync(cfg *config.Config) error {
+func (wm *WhitelistManager) Sync(ctx context.Context, cfg *config.Config) error {
 	client := utils.NewAPIClient(cfg)
 
-	resp, err := client.DoRequest(utils.RequestOptions{
+	resp, err := client.DoRequest(ctx, utils.RequestOptions{
 		Endpoint: "/sync/whitelist",
 	})
 	if err != nil {
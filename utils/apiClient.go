@@ -1,54 +1,237 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
 	"go.uber.org/zap"
 )
 
+// Caps the wait between attempts unless the server asks for longer with Retry-After
+const maxBackoff = 30 * time.Second
+
 type APIClient struct {
-	cfg        *config.Config
+	baseURL string
+	// headers are set on every request, e.g. for authentication
+	headers    map[string]string
 	httpClient *http.Client
+	timeout    time.Duration
+	deadline   time.Duration
+	// limiter paces every attempt, nil for no limit
+	limiter *RateLimiter
 }
 
 type RequestOptions struct {
-	Method      string
-	Endpoint    string
-	Body        io.Reader
+	Method   string
+	Endpoint string
+	Body     io.Reader
+	// MaxRetries defaults to 3, a negative value disables retries
 	MaxRetries  int
 	InitBackoff time.Duration
+	// Headers are set in addition to the client's headers
+	Headers map[string]string
+	// Timeout bounds the whole request including retries and reading the response body,
+	// it defaults to cfg.APIRequestDeadline
+	Timeout time.Duration
+}
+
+// ErrorKind classifies failed API requests
+type ErrorKind string
+
+const (
+	KindNetwork   ErrorKind = "network"    // no response, e.g. connection refused or timed out
+	KindThrottled ErrorKind = "throttled"  // not sent, the local request budget is exhausted
+	KindRateLimit ErrorKind = "rate-limit" // 429
+	KindAuth      ErrorKind = "auth"       // 401 and 403
+	KindClient    ErrorKind = "client"     // other 4xx
+	KindServer    ErrorKind = "server"     // 5xx
+)
+
+// StatusError is returned when the API answers with an unsuccessful status
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	// RetryAfter is the wait requested by the server, 0 if none
+	RetryAfter time.Duration
 }
 
-// Creates a new API client with the given config
+func (e *StatusError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("API returned status %s: %s", e.Status, e.Body)
+	}
+	return fmt.Sprintf("API returned status %s", e.Status)
+}
+
+// Kind classifies the status
+func (e *StatusError) Kind() ErrorKind {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return KindRateLimit
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return KindAuth
+	case e.StatusCode >= 400 && e.StatusCode < 500:
+		return KindClient
+	default:
+		return KindServer
+	}
+}
+
+// ErrorKindOf classifies an error returned by DoRequest, errors without a status are
+// network errors. It returns an empty kind for nil.
+func ErrorKindOf(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrThrottled) {
+		return KindThrottled
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Kind()
+	}
+	return KindNetwork
+}
+
+// RetryAfterOf returns the wait requested by the server along with an error, 0 if none
+func RetryAfterOf(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+var (
+	sharedHTTPClient *http.Client
+	httpClientOnce   sync.Once
+)
+
+// httpClient returns the HTTP client shared by all API clients, so that they reuse connections
+func httpClient() *http.Client {
+	httpClientOnce.Do(func() {
+		sharedHTTPClient = &http.Client{Transport: newTransport()}
+	})
+	return sharedHTTPClient
+}
+
+// Creates a new API client for the arbiter with the given config
 func NewAPIClient(cfg *config.Config) *APIClient {
+	return NewClient(cfg, cfg.NfgArbiterUrl, map[string]string{
+		"X_AUTH_KEY":    cfg.AuthSecret,
+		"X_SENSOR_NAME": cfg.SensorName,
+	})
+}
+
+// NewClient creates a client for the API at baseURL, setting headers on every request
+func NewClient(cfg *config.Config, baseURL string, headers map[string]string) *APIClient {
 	return &APIClient{
-		cfg:        cfg,
-		httpClient: http.DefaultClient,
+		baseURL:    baseURL,
+		headers:    headers,
+		httpClient: httpClient(),
+		timeout:    cfg.APIRequestTimeout,
+		deadline:   cfg.APIRequestDeadline,
 	}
 }
 
-// Performs an authenticated HTTP request with retry logic
-// Returns the response body and any error encountered
-func (c *APIClient) DoRequest(opts RequestOptions) (*http.Response, error) {
+// SetRateLimiter makes every attempt wait for the endpoint's budget
+func (c *APIClient) SetRateLimiter(limiter *RateLimiter) {
+	c.limiter = limiter
+}
+
+// cancelOnClose releases the request's context once the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// jitter spreads a backoff over [d/2, 3d/2) so that sensors do not retry in lockstep
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if retryTime, err := http.ParseTime(value); err == nil {
+		return time.Until(retryTime)
+	}
+	return 0
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Performs an authenticated HTTP request with retry logic. Network errors, 408, 429
+// and 5xx responses are retried with jittered exponential backoff, or after the wait
+// given by Retry-After. Each attempt must see a response within cfg.APIRequestTimeout.
+// Failed statuses are returned as *StatusError. The response body must be closed.
+func (c *APIClient) DoRequest(ctx context.Context, opts RequestOptions) (*http.Response, error) {
 	// Set defaults
 	if opts.Method == "" {
 		opts.Method = "GET"
 	}
 	if opts.MaxRetries == 0 {
 		opts.MaxRetries = 3
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
 	}
 	if opts.InitBackoff == 0 {
 		opts.InitBackoff = time.Second
 	}
+	if opts.Timeout == 0 {
+		opts.Timeout = c.deadline
+	}
 
-	url := fmt.Sprintf("%s%s", c.cfg.NfgArbiterUrl, opts.Endpoint)
+	url := fmt.Sprintf("%s%s", c.baseURL, opts.Endpoint)
 	backoff := opts.InitBackoff
 
+	// Buffer the body so that every attempt sends it in full
+	var body []byte
+	if opts.Body != nil {
+		var err error
+		if body, err = io.ReadAll(opts.Body); err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	var cancel context.CancelFunc = func() {}
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+	}
+
 	zap.L().Debug("Starting API request",
 		zap.String("method", opts.Method),
 		zap.String("url", url),
@@ -56,135 +239,146 @@ func (c *APIClient) DoRequest(opts RequestOptions) (*http.Response, error) {
 
 	var lastErr error
 	for attempt := 0; attempt <= opts.MaxRetries; attempt++ {
-		req, err := http.NewRequest(opts.Method, url, opts.Body)
-		if err != nil {
-			zap.L().Error("Failed to create API request",
-				zap.String("url", url),
-				zap.Error(err),
-			)
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		// Set authentication headers
-		req.Header.Set("X_AUTH_KEY", c.cfg.AuthSecret)
-		req.Header.Set("X_SENSOR_NAME", c.cfg.SensorName)
+		if attempt > 0 {
+			wait := jitter(backoff)
+			if retryAfter := RetryAfterOf(lastErr); retryAfter > 0 {
+				wait = retryAfter
+			}
+			backoff = min(backoff*2, maxBackoff)
 
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			lastErr = err
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+				zap.L().Warn("API request deadline reached before next retry",
+					zap.String("url", url),
+					zap.Duration("wait", wait),
+					zap.Error(lastErr),
+				)
+				break
+			}
 			zap.L().Warn("API request failed, retrying",
-				zap.Int("attempt", attempt+1),
+				zap.Int("attempt", attempt),
 				zap.Int("maxRetries", opts.MaxRetries),
 				zap.String("url", url),
-				zap.Error(err),
+				zap.Duration("wait", wait),
+				zap.Error(lastErr),
 			)
-			if attempt < opts.MaxRetries {
-				time.Sleep(backoff)
-				backoff *= 2
-				continue
+			if err := sleep(ctx, wait); err != nil {
+				break
 			}
-			zap.L().Error("API request failed after retries",
-				zap.Int("maxRetries", opts.MaxRetries),
-				zap.String("url", url),
-				zap.Error(err),
-			)
-			return nil, fmt.Errorf("failed to fetch data after retries: %w", err)
 		}
 
-		// Success case
-		if resp.StatusCode == http.StatusOK {
-			zap.L().Debug("API request successful",
-				zap.String("url", url),
-				zap.Int("status", resp.StatusCode),
-			)
-			return resp, nil
-		}
-
-		// Handle rate limiting with exponential backoff
-		if resp.StatusCode == http.StatusTooManyRequests {
-			resp.Body.Close()
-
-			// Check for Retry-After header
-			retryAfter := resp.Header.Get("Retry-After")
-			var waitDuration time.Duration
-
-			if retryAfter != "" {
-				// Try parsing as seconds (integer)
-				if seconds, err := strconv.Atoi(retryAfter); err == nil {
-					waitDuration = time.Duration(seconds) * time.Second
-				} else {
-					// Try parsing as HTTP date
-					if retryTime, err := http.ParseTime(retryAfter); err == nil {
-						waitDuration = time.Until(retryTime)
-					}
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx, opts.Endpoint); err != nil {
+				if lastErr == nil {
+					lastErr = err
 				}
+				break
 			}
-
-			// Fall back to exponential backoff if no valid Retry-After
-			if waitDuration <= 0 {
-				waitDuration = backoff
-			}
-
-			if attempt < opts.MaxRetries {
-				zap.L().Info("Rate limited, waiting before retry",
-					zap.Int("attempt", attempt+1),
-					zap.Int("maxRetries", opts.MaxRetries),
-					zap.String("url", url),
-					zap.Duration("waitDuration", waitDuration),
-				)
-				time.Sleep(waitDuration)
-				backoff *= 2
-				continue
-			}
-
-			zap.L().Warn("Rate limited after all retries",
-				zap.Int("maxRetries", opts.MaxRetries),
-				zap.String("url", url),
-			)
-			return nil, fmt.Errorf("rate limited after %d retries", opts.MaxRetries)
 		}
 
-		// Retry on 5xx errors
-		if resp.StatusCode >= 500 && attempt < opts.MaxRetries {
-			resp.Body.Close()
-			zap.L().Warn("Server error, retrying",
-				zap.Int("attempt", attempt+1),
-				zap.Int("maxRetries", opts.MaxRetries),
-				zap.String("url", url),
-				zap.Int("status", resp.StatusCode),
-			)
-			time.Sleep(backoff)
-			backoff *= 2
+		resp, err := c.attempt(ctx, opts, url, body)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil || !retriable(err) {
+				break
+			}
 			continue
 		}
 
-		// Check for rate limit
-		if resp.StatusCode == http.StatusTooManyRequests {
-			return nil, fmt.Errorf("API returned status %s", resp.Status)
-		}
-
-		// Non-retriable error
-		resp.Body.Close()
-		zap.L().Error("API returned non-retriable status",
+		zap.L().Debug("API request successful",
 			zap.String("url", url),
 			zap.Int("status", resp.StatusCode),
-			zap.String("statusText", resp.Status),
 		)
-		return nil, fmt.Errorf("API returned status %s", resp.Status)
+		resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
 	}
+	cancel()
 
-	zap.L().Error("API request failed after all retries",
-		zap.Int("maxRetries", opts.MaxRetries),
+	switch ErrorKindOf(lastErr) {
+	case KindThrottled:
+		zap.L().Debug("API request throttled locally", zap.String("url", url))
+		return nil, fmt.Errorf("API request to %s not sent: %w", url, lastErr)
+	case KindNetwork:
+		zap.L().Error("API request failed",
+			zap.String("url", url),
+			zap.Error(lastErr),
+		)
+		return nil, fmt.Errorf("API request to %s failed: %w", url, lastErr)
+	}
+	zap.L().Error("API returned unsuccessful status",
 		zap.String("url", url),
+		zap.String("kind", string(ErrorKindOf(lastErr))),
+		zap.Error(lastErr),
 	)
-	return nil, fmt.Errorf("request failed after %d retries: %w", opts.MaxRetries, lastErr)
+	return nil, lastErr
 }
 
-// Same as DoRequest but panics on error
-func (c *APIClient) MustDoRequest(opts RequestOptions) *http.Response {
-	resp, err := c.DoRequest(opts)
+// attempt sends a request once. Unsuccessful statuses are returned as *StatusError.
+func (c *APIClient) attempt(ctx context.Context, opts RequestOptions, url string, body []byte) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(opts.Method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range opts.Headers {
+		req.Header.Set(key, value)
+	}
+
+	// The attempt times out unless response headers arrive in time
+	attemptCtx, cancel := context.WithCancel(ctx)
+	stopTimer := func() bool { return true }
+	if c.timeout > 0 {
+		stopTimer = time.AfterFunc(c.timeout, cancel).Stop
+	}
+
+	resp, err := c.httpClient.Do(req.WithContext(attemptCtx))
+	if !stopTimer() && ctx.Err() == nil {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("no response within %s: %w", c.timeout, context.DeadlineExceeded)
+	}
 	if err != nil {
-		panic(err)
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		cancel()
+		statusErr := &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(bytes.TrimSpace(bodyBytes)),
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		return nil, statusErr
+	}
+
+	// The overall deadline still applies to reading the body
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// retriable reports whether a failed attempt may succeed when repeated
+func retriable(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	switch statusErr.Kind() {
+	case KindRateLimit, KindServer:
+		return true
 	}
-	return resp
+	return statusErr.StatusCode == http.StatusRequestTimeout
 }
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+)
+
+// proxyFunc selects the proxy for outgoing requests, set by InitTransport
+var proxyFunc = http.ProxyFromEnvironment
+
+// Proxy returns the proxy for a request, nil for a direct connection. It is used by
+// the REST client, the WebSocket dialer, heartbeats and Loki shipping.
+func Proxy(req *http.Request) (*url.URL, error) {
+	return proxyFunc(req)
+}
+
+// ProxyFor describes the proxy used to reach rawURL, with credentials redacted
+func ProxyFor(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "unknown"
+	}
+	proxyURL, err := Proxy(&http.Request{URL: u})
+	if err != nil {
+		return "invalid: " + err.Error()
+	}
+	if proxyURL == nil {
+		return "direct"
+	}
+	return proxyURL.Redacted()
+}
+
+// newProxyFunc builds the proxy selection from the config. Without OUTBOUND_PROXY the
+// standard HTTPS_PROXY, HTTP_PROXY and NO_PROXY variables apply.
+func newProxyFunc(cfg *config.Config) (func(*http.Request) (*url.URL, error), error) {
+	noProxy, err := parseNoProxy(cfg.OutboundNoProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.OutboundProxy != "" {
+		proxyURL, err := url.Parse(cfg.OutboundProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OUTBOUND_PROXY: %w", err)
+		}
+		// Both the HTTP transport and the WebSocket dialer support these
+		if proxyURL.Scheme != "http" && proxyURL.Scheme != "socks5" {
+			return nil, fmt.Errorf("invalid OUTBOUND_PROXY %q: scheme must be http or socks5", proxyURL.Redacted())
+		}
+		if proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid OUTBOUND_PROXY %q: missing host", proxyURL.Redacted())
+		}
+		if cfg.OutboundProxyUsername != "" {
+			proxyURL.User = url.UserPassword(cfg.OutboundProxyUsername, cfg.OutboundProxyPassword)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	if !noProxy.all && len(noProxy.networks) == 0 && len(noProxy.domains) == 0 {
+		return proxy, nil
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxy.matches(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxy(req)
+	}, nil
+}
+
+// noProxyList holds the hosts reached without the proxy
+type noProxyList struct {
+	all      bool
+	networks []*net.IPNet
+	// domains match the domain and its subdomains, or only subdomains with a leading dot
+	domains []string
+}
+
+// parseNoProxy reads entries like "*", "10.0.0.0/8", "192.168.1.1", "example.com" and ".example.com"
+func parseNoProxy(entries []string) (noProxyList, error) {
+	var list noProxyList
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "*":
+			list.all = true
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return noProxyList{}, fmt.Errorf("invalid OUTBOUND_NO_PROXY entry %q: %w", entry, err)
+			}
+			list.networks = append(list.networks, network)
+		case net.ParseIP(entry) != nil:
+			ip := net.ParseIP(entry)
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			list.networks = append(list.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		case entry != "":
+			list.domains = append(list.domains, entry)
+		}
+	}
+	return list, nil
+}
+
+func (l noProxyList) matches(host string) bool {
+	if l.all {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range l.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, domain := range l.domains {
+		if strings.HasPrefix(domain, ".") {
+			if strings.HasSuffix(host, domain) {
+				return true
+			}
+		} else if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a request budget: Rate requests per second with bursts of up to Burst
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ErrThrottled is returned when the request budget allows no request before the deadline
+var ErrThrottled = errors.New("request budget exhausted until the deadline")
+
+// tokenBucket is refilled at limit.Rate up to limit.Burst tokens. Waiting callers
+// reserve a token up front, so the balance may go negative.
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// delay refills the bucket and returns how long a new reservation would wait
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate, float64(b.limit.Burst))
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// RateLimiter keeps a separate token bucket per endpoint, shared by all requests
+type RateLimiter struct {
+	mu           sync.Mutex
+	defaultLimit RateLimit
+	limits       map[string]RateLimit
+	buckets      map[string]*tokenBucket
+}
+
+// NewRateLimiter creates a limiter applying defaultLimit to endpoints without their own
+// limit. A zero rate means no limit.
+func NewRateLimiter(defaultLimit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		defaultLimit: defaultLimit,
+		limits:       make(map[string]RateLimit),
+		buckets:      make(map[string]*tokenBucket),
+	}
+}
+
+// SetLimits replaces the per-endpoint limits, e.g. with the ones announced by the server.
+// It returns false if they are unchanged.
+func (l *RateLimiter) SetLimits(limits map[string]RateLimit) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if maps.Equal(l.limits, limits) {
+		return false
+	}
+	l.limits = maps.Clone(limits)
+	// Buckets are recreated with the new sizes on their next use
+	l.buckets = make(map[string]*tokenBucket)
+	return true
+}
+
+// Wait blocks until the endpoint's budget allows another request or ctx is done. If the
+// wait would pass the ctx deadline it returns ErrThrottled at once, without taking a token.
+func (l *RateLimiter) Wait(ctx context.Context, endpoint string) error {
+	endpoint, _, _ = strings.Cut(endpoint, "?")
+
+	l.mu.Lock()
+	bucket, ok := l.buckets[endpoint]
+	if !ok {
+		limit, found := l.limits[endpoint]
+		if !found {
+			limit = l.defaultLimit
+		}
+		limit.Burst = max(limit.Burst, 1)
+		bucket = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+		l.buckets[endpoint] = bucket
+	}
+	if bucket.limit.Rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	wait := bucket.delay(now)
+	if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+		l.mu.Unlock()
+		return ErrThrottled
+	}
+	bucket.tokens--
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	if err := sleep(ctx, wait); err != nil {
+		// Give the reserved token back
+		l.mu.Lock()
+		bucket.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+)
+
+// tlsConfig is used for all outgoing connections to NxtFireGuard services,
+// nil for the system defaults
+var tlsConfig *tls.Config
+
+// TLSConfig returns a copy of the TLS settings loaded by InitTransport
+func TLSConfig() *tls.Config {
+	if tlsConfig == nil {
+		return &tls.Config{}
+	}
+	return tlsConfig.Clone()
+}
+
+// NewTLSConfig builds the client TLS settings from the config, nil if none are set.
+// With a CA bundle only its CAs are trusted, not the system store. With a client
+// certificate the sensor authenticates itself (mTLS). With SPKI pins the server
+// chain must contain a certificate whose public key matches one of them.
+func NewTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.ArbiterTLSCAFile == "" && cfg.ArbiterTLSCertFile == "" && cfg.ArbiterTLSKeyFile == "" && len(cfg.ArbiterTLSPins) == 0 {
+		return nil, nil
+	}
+	clientConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.ArbiterTLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.ArbiterTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.ArbiterTLSCAFile)
+		}
+		clientConfig.RootCAs = pool
+	}
+
+	if cfg.ArbiterTLSCertFile != "" || cfg.ArbiterTLSKeyFile != "" {
+		if cfg.ArbiterTLSCertFile == "" || cfg.ArbiterTLSKeyFile == "" {
+			return nil, errors.New("ARBITER_TLS_CERT_FILE and ARBITER_TLS_KEY_FILE must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ArbiterTLSCertFile, cfg.ArbiterTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		clientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(cfg.ArbiterTLSPins) > 0 {
+		pins, err := parsePins(cfg.ArbiterTLSPins)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state, pins)
+		}
+	}
+	return clientConfig, nil
+}
+
+// parsePins decodes base64 SHA-256 SPKI hashes, optionally prefixed with "sha256/"
+func parsePins(values []string) (map[[sha256.Size]byte]bool, error) {
+	pins := make(map[[sha256.Size]byte]bool, len(values))
+	for _, value := range values {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "sha256/"))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid SPKI pin %q: expected a base64 SHA-256 hash", value)
+		}
+		pins[[sha256.Size]byte(hash)] = true
+	}
+	return pins, nil
+}
+
+// verifyPins checks the verified chains for a pinned public key. Without verification
+// (STREAMING_SKIP_VERIFY_TLS) only the server certificate itself is checked.
+func verifyPins(state tls.ConnectionState, pins map[[sha256.Size]byte]bool) error {
+	var certs []*x509.Certificate
+	for _, chain := range state.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(state.VerifiedChains) == 0 && len(state.PeerCertificates) > 0 {
+		certs = state.PeerCertificates[:1]
+	}
+	for _, cert := range certs {
+		if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+			return nil
+		}
+	}
+	return errors.New("server certificate matches none of the configured SPKI pins")
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"net/http"
+
+	"github.com/NxtGenIT/nxtfireguard-traffic-sensor/config"
+)
+
+// InitTransport loads the TLS and proxy settings for the arbiter REST API and WebSocket,
+// heartbeats and Loki shipping. It must be called before any of them are used.
+func InitTransport(cfg *config.Config) error {
+	clientConfig, err := NewTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	proxy, err := newProxyFunc(cfg)
+	if err != nil {
+		return err
+	}
+	tlsConfig, proxyFunc = clientConfig, proxy
+
+	// zap-loki sends through the default transport
+	http.DefaultTransport = newTransport()
+	return nil
+}
+
+// newTransport returns an HTTP transport with the TLS and proxy settings
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = TLSConfig()
+	transport.Proxy = Proxy
+	return transport
+}